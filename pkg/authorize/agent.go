@@ -0,0 +1,101 @@
+package authorize
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/pkg/errors"
+	"github.com/solo-io/gloo-connect/pkg/consul"
+)
+
+// StatusError is returned when the agent answered with an error status
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("agent returned %v: %v", e.StatusCode, strings.TrimSpace(e.Body))
+}
+
+// HTTPAgent calls the agent's authorize endpoint with plain http requests,
+// so errors carry the status code the agent answered with
+type HTTPAgent struct {
+	client  *http.Client
+	address string
+	token   string
+}
+
+func NewHTTPAgent(cfg *api.Config) (*HTTPAgent, error) {
+	client, address, err := consul.NewAgentHTTPClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &HTTPAgent{
+		client:  client,
+		address: address,
+		token:   cfg.Token,
+	}, nil
+}
+
+func (a *HTTPAgent) ConnectAuthorize(params *api.AgentAuthorizeParams) (*api.AgentAuthorize, error) {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	var out api.AgentAuthorize
+	if err := a.do(http.MethodPost, Path, bytes.NewReader(body), &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// IntentionDefaultAllow mirrors the agent: with acls disabled everything is
+// allowed, otherwise the acl default policy decides
+func (a *HTTPAgent) IntentionDefaultAllow() (bool, error) {
+	var self struct {
+		DebugConfig struct {
+			ACLDatacenter    string
+			ACLDefaultPolicy string
+		}
+	}
+	if err := a.do(http.MethodGet, "/v1/agent/self", nil, &self); err != nil {
+		return false, err
+	}
+	if self.DebugConfig.ACLDatacenter == "" {
+		return true, nil
+	}
+	return self.DebugConfig.ACLDefaultPolicy != "deny", nil
+}
+
+func (a *HTTPAgent) do(method, path string, body io.Reader, out interface{}) error {
+	req, err := http.NewRequest(method, a.address+path, body)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if a.token != "" {
+		req.Header.Set("X-Consul-Token", a.token)
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return &StatusError{StatusCode: resp.StatusCode, Body: string(msg)}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return errors.Wrapf(err, "decoding response of %v", path)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package authorize
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+const (
+	decidedByIntentions    = "intentions"
+	decidedByAgent         = "agent"
+	decidedByFailurePolicy = "failure_policy"
+)
+
+// AuditRecord is written for every authorization decision
+type AuditRecord struct {
+	Time time.Time `json:"time"`
+	// spiffe id of the client certificate
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	// allow or deny
+	Decision string `json:"decision"`
+	Reason   string `json:"reason"`
+	// id of the intention that matched, if known
+	Intention string `json:"intention,omitempty"`
+	// intentions, agent or failure_policy
+	DecidedBy string  `json:"decided_by"`
+	LatencyMs float64 `json:"latency_ms"`
+}
+
+// AuditLog writes audit records as json lines
+type AuditLog struct {
+	lock   sync.Mutex
+	out    io.Writer
+	closer io.Closer
+}
+
+func NewAuditLog(out io.Writer) *AuditLog {
+	return &AuditLog{out: out}
+}
+
+// OpenAuditLog appends to the file at path, or writes to stdout if path is "-"
+func OpenAuditLog(path string) (*AuditLog, error) {
+	if path == "-" {
+		return NewAuditLog(os.Stdout), nil
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &AuditLog{out: f, closer: f}, nil
+}
+
+func (a *AuditLog) Write(record *AuditRecord) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return json.NewEncoder(a.out).Encode(record)
+}
+
+func (a *AuditLog) Close() error {
+	if a.closer == nil {
+		return nil
+	}
+	return a.closer.Close()
+}
+
+// the agent reports the matching intention in the reason, e.g.
+// "Matched intention: default/test => default/web (ID: 8a2ee8d6-..., Precedence: 9)"
+var agentIntentionIDRegexp = regexp.MustCompile(`\(ID: ([0-9a-fA-F-]+)`)
+
+func newAuditRecord(params *api.AgentAuthorizeParams, resp *api.AgentAuthorize, d decision, latency time.Duration) *AuditRecord {
+	record := &AuditRecord{
+		Time:        time.Now().UTC(),
+		Source:      params.ClientCertURI,
+		Destination: params.Target,
+		Decision:    "deny",
+		Reason:      resp.Reason,
+		Intention:   d.intention,
+		DecidedBy:   d.decidedBy,
+		LatencyMs:   float64(latency) / float64(time.Millisecond),
+	}
+	if resp.Authorized {
+		record.Decision = "allow"
+	}
+	if record.Intention == "" && d.decidedBy == decidedByAgent {
+		if match := agentIntentionIDRegexp.FindStringSubmatch(resp.Reason); match != nil {
+			record.Intention = match[1]
+		}
+	}
+	return record
+}
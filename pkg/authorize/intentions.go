@@ -0,0 +1,158 @@
+package authorize
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/pkg/errors"
+	"github.com/solo-io/gloo-connect/pkg/backoff"
+	"github.com/solo-io/gloo/pkg/log"
+)
+
+const intentionWildcard = "*"
+
+type IntentionsClient interface {
+	IntentionMatch(args *api.IntentionMatch, q *api.QueryOptions) (map[string][]*api.Intention, *api.QueryMeta, error)
+}
+
+// the cache is marked unsynced after this many consecutive watch errors, so
+// the agent decides again instead of intentions that may be stale
+const maxWatchFailures = 3
+
+// intentionCache holds the intentions for a destination service,
+// kept up to date with a blocking query
+type intentionCache struct {
+	target string
+	cancel context.CancelFunc
+	// signalled when the intentions or the synced state changed
+	changes chan<- struct{}
+
+	lock   sync.RWMutex
+	synced bool
+	// sorted by precedence, as returned by consul
+	intentions []*api.Intention
+	// whether connections that match no intention are allowed
+	defaultAllow bool
+}
+
+func watchIntentions(ctx context.Context, client IntentionsClient, agent AgentClient, target string, changes chan<- struct{}) *intentionCache {
+	ctx, cancel := context.WithCancel(ctx)
+	c := &intentionCache{
+		target:  target,
+		cancel:  cancel,
+		changes: changes,
+	}
+	go c.run(ctx, client, agent)
+	return c
+}
+
+func (c *intentionCache) stop() {
+	c.cancel()
+}
+
+func (c *intentionCache) run(ctx context.Context, client IntentionsClient, agent AgentClient) {
+	match := &api.IntentionMatch{
+		By:    api.IntentionMatchDestination,
+		Names: []string{c.target},
+	}
+	var (
+		q        *api.QueryOptions
+		retry    backoff.Backoff
+		failures int
+	)
+	for {
+		q = q.WithContext(ctx)
+		matches, query, err := client.IntentionMatch(match, q)
+		var defaultAllow bool
+		if err == nil {
+			defaultAllow, err = agent.IntentionDefaultAllow()
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			failures++
+			log.Warnf("failed to watch intentions for %v: %v", c.target, err)
+			if failures == maxWatchFailures {
+				log.Warnf("intentions for %v are out of date; leaving authorization to the agent until they are synced again", c.target)
+				c.set(false, nil, false)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(retry.Next()):
+			}
+			continue
+		}
+		failures = 0
+		retry.Reset()
+		q = &api.QueryOptions{
+			WaitIndex: query.LastIndex,
+		}
+		c.set(true, matches[c.target], defaultAllow)
+		log.Printf("synced %v intentions for %v", len(matches[c.target]), c.target)
+	}
+}
+
+func (c *intentionCache) set(synced bool, intentions []*api.Intention, defaultAllow bool) {
+	c.lock.Lock()
+	c.synced = synced
+	c.intentions = intentions
+	c.defaultAllow = defaultAllow
+	c.lock.Unlock()
+	select {
+	case c.changes <- struct{}{}:
+	default:
+	}
+}
+
+// get returns the intentions and the default; ok is false if the cache is not synced
+func (c *intentionCache) get() (intentions []*api.Intention, defaultAllow bool, ok bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.intentions, c.defaultAllow, c.synced
+}
+
+// match returns the intention with the highest precedence that applies to the
+// source service. ok is false if the cache is not synced yet.
+func (c *intentionCache) match(sourceNS, sourceName string) (intention *api.Intention, ok bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if !c.synced {
+		return nil, false
+	}
+	for _, ixn := range c.intentions {
+		if (ixn.SourceNS == intentionWildcard || ixn.SourceNS == sourceNS) &&
+			(ixn.SourceName == intentionWildcard || ixn.SourceName == sourceName) {
+			return ixn, true
+		}
+	}
+	return nil, true
+}
+
+// parseServiceURI returns the namespace and service of a connect service
+// identity, e.g. spiffe://<trust-domain>/ns/default/dc/dc1/svc/web
+func parseServiceURI(uri string) (namespace, service string, err error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", "", err
+	}
+	if u.Scheme != "spiffe" {
+		return "", "", errors.Errorf("%v is not a spiffe id", uri)
+	}
+	parts := strings.Split(strings.TrimPrefix(u.Path, "/"), "/")
+	if len(parts) != 6 || parts[0] != "ns" || parts[2] != "dc" || parts[4] != "svc" {
+		return "", "", errors.Errorf("%v is not a connect service identity", uri)
+	}
+	return parts[1], parts[5], nil
+}
+
+func intentionReason(ixn *api.Intention) string {
+	return fmt.Sprintf("Matched intention %v: %v/%v => %v/%v (%v)",
+		ixn.ID, ixn.SourceNS, ixn.SourceName, ixn.DestinationNS, ixn.DestinationName, ixn.Action)
+}
@@ -0,0 +1,85 @@
+package authorize
+
+import (
+	"fmt"
+	"regexp"
+
+	rbac "github.com/envoyproxy/go-control-plane/envoy/config/rbac/v2alpha"
+	"github.com/envoyproxy/go-control-plane/envoy/type/matcher"
+	"github.com/hashicorp/consul/api"
+)
+
+const defaultPolicyName = "default"
+
+// RBAC renders the intentions of a destination into rules for envoy's rbac
+// filter, so envoy decides inbound connections without asking the bridge.
+// The intentions are sorted by precedence, and the first one that matches a
+// source decides; sources that match none are allowed if defaultAllow is set.
+//
+// The rules only allow: each allow intention becomes a policy for the sources
+// it matches, minus those of the deny intentions that take precedence.
+func RBAC(intentions []*api.Intention, defaultAllow bool) *rbac.RBAC {
+	rules := &rbac.RBAC{
+		Action:   rbac.RBAC_ALLOW,
+		Policies: make(map[string]*rbac.Policy),
+	}
+	var denied []*rbac.Principal
+	for _, ixn := range intentions {
+		if ixn.Action != api.IntentionActionAllow {
+			denied = append(denied, sourcePrincipal(ixn))
+			continue
+		}
+		rules.Policies[ixn.ID] = allowPolicy(sourcePrincipal(ixn), denied)
+	}
+	if defaultAllow {
+		rules.Policies[defaultPolicyName] = allowPolicy(&rbac.Principal{
+			Identifier: &rbac.Principal_Any{Any: true},
+		}, denied)
+	}
+	return rules
+}
+
+func allowPolicy(source *rbac.Principal, denied []*rbac.Principal) *rbac.Policy {
+	principal := source
+	if len(denied) > 0 {
+		ids := []*rbac.Principal{source}
+		for _, d := range denied {
+			ids = append(ids, &rbac.Principal{
+				Identifier: &rbac.Principal_NotId{NotId: d},
+			})
+		}
+		principal = &rbac.Principal{
+			Identifier: &rbac.Principal_AndIds{AndIds: &rbac.Principal_Set{Ids: ids}},
+		}
+	}
+	return &rbac.Policy{
+		Permissions: []*rbac.Permission{{
+			Rule: &rbac.Permission_Any{Any: true},
+		}},
+		Principals: []*rbac.Principal{principal},
+	}
+}
+
+// sourcePrincipal matches the client certificates of the intention's source,
+// in any trust domain and datacenter
+func sourcePrincipal(ixn *api.Intention) *rbac.Principal {
+	return &rbac.Principal{
+		Identifier: &rbac.Principal_Authenticated_{
+			Authenticated: &rbac.Principal_Authenticated{
+				PrincipalName: &matcher.StringMatcher{
+					MatchPattern: &matcher.StringMatcher_Regex{
+						Regex: fmt.Sprintf("^spiffe://[^/]+/ns/%v/dc/[^/]+/svc/%v$",
+							sourcePattern(ixn.SourceNS), sourcePattern(ixn.SourceName)),
+					},
+				},
+			},
+		},
+	}
+}
+
+func sourcePattern(name string) string {
+	if name == intentionWildcard {
+		return "[^/]+"
+	}
+	return regexp.QuoteMeta(name)
+}
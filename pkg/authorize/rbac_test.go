@@ -0,0 +1,87 @@
+package authorize_test
+
+import (
+	"regexp"
+
+	rbac "github.com/envoyproxy/go-control-plane/envoy/config/rbac/v2alpha"
+	"github.com/hashicorp/consul/api"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/solo-io/gloo-connect/pkg/authorize"
+)
+
+// allowed evaluates the rules the way envoy's rbac filter does for a client certificate uri
+func allowed(rules *rbac.RBAC, uri string) bool {
+	for _, policy := range rules.Policies {
+		for _, principal := range policy.Principals {
+			if matches(principal, uri) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matches(principal *rbac.Principal, uri string) bool {
+	switch id := principal.Identifier.(type) {
+	case *rbac.Principal_Any:
+		return id.Any
+	case *rbac.Principal_Authenticated_:
+		return regexp.MustCompile(id.Authenticated.PrincipalName.GetRegex()).MatchString(uri)
+	case *rbac.Principal_NotId:
+		return !matches(id.NotId, uri)
+	case *rbac.Principal_AndIds:
+		for _, p := range id.AndIds.Ids {
+			if !matches(p, uri) {
+				return false
+			}
+		}
+		return true
+	}
+	Fail("unexpected principal")
+	return false
+}
+
+var _ = Describe("RBAC", func() {
+	source := func(name string) string {
+		return "spiffe://11111111-2222-3333-4444-555555555555.consul/ns/default/dc/dc1/svc/" + name
+	}
+	intention := func(source string, action api.IntentionAction) *api.Intention {
+		return &api.Intention{
+			ID:              source + "-to-web",
+			SourceNS:        "default",
+			SourceName:      source,
+			DestinationNS:   "default",
+			DestinationName: "web",
+			Action:          action,
+		}
+	}
+
+	It("lets the intention with the highest precedence decide", func() {
+		rules := RBAC([]*api.Intention{
+			intention("test", api.IntentionActionDeny),
+			intention("*", api.IntentionActionAllow),
+		}, false)
+		Expect(allowed(rules, source("test"))).To(BeFalse())
+		Expect(allowed(rules, source("other"))).To(BeTrue())
+	})
+
+	It("applies the default to sources no intention matches", func() {
+		ixns := []*api.Intention{
+			intention("test", api.IntentionActionAllow),
+			intention("blocked", api.IntentionActionDeny),
+		}
+		Expect(allowed(RBAC(ixns, false), source("other"))).To(BeFalse())
+		Expect(allowed(RBAC(ixns, true), source("other"))).To(BeTrue())
+		Expect(allowed(RBAC(ixns, true), source("blocked"))).To(BeFalse())
+		Expect(allowed(RBAC(ixns, true), source("test"))).To(BeTrue())
+	})
+
+	It("only matches whole service names", func() {
+		rules := RBAC([]*api.Intention{intention("test", api.IntentionActionAllow)}, false)
+		Expect(allowed(rules, source("test-2"))).To(BeFalse())
+		Expect(allowed(rules, "spiffe://example.org/not-a-service")).To(BeFalse())
+	})
+})
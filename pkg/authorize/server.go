@@ -0,0 +1,278 @@
+package authorize
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/pkg/errors"
+	"github.com/solo-io/gloo/pkg/log"
+)
+
+// Path is where the agent serves authorize requests. The local authorizer
+// serves the same api on the same path, so envoy can talk to either.
+const Path = "/v1/agent/connect/authorize"
+
+type FailurePolicy string
+
+const (
+	// deny connections when the agent can't be reached in time
+	FailClosed FailurePolicy = "closed"
+	// allow connections when the agent can't be reached in time
+	FailOpen FailurePolicy = "open"
+)
+
+func ParseFailurePolicy(policy string) (FailurePolicy, error) {
+	switch FailurePolicy(policy) {
+	case "":
+		return FailClosed, nil
+	case FailClosed, FailOpen:
+		return FailurePolicy(policy), nil
+	}
+	return "", errors.Errorf("invalid authorize failure policy %q, must be %q or %q", policy, FailClosed, FailOpen)
+}
+
+type Mode string
+
+const (
+	// ask the agent for every connection
+	ModeAgent Mode = "agent"
+	// decide locally from the watched intentions of the target service.
+	// connections that match no intention are left to the agent,
+	// which applies the default acl policy
+	ModeIntentions Mode = "intentions"
+)
+
+func ParseMode(mode string) (Mode, error) {
+	switch Mode(mode) {
+	case "":
+		return ModeAgent, nil
+	case ModeAgent, ModeIntentions:
+		return Mode(mode), nil
+	}
+	return "", errors.Errorf("invalid authorize mode %q, must be %q or %q", mode, ModeAgent, ModeIntentions)
+}
+
+type Settings struct {
+	// the service connections are authorized to
+	Target string
+	Mode   Mode
+	// how long to wait for the agent; zero waits forever
+	Timeout time.Duration
+	// what to answer when the agent can't be reached in time
+	FailurePolicy FailurePolicy
+}
+
+type AgentClient interface {
+	ConnectAuthorize(auth *api.AgentAuthorizeParams) (*api.AgentAuthorize, error)
+	// whether connections that match no intention are allowed
+	IntentionDefaultAllow() (bool, error)
+}
+
+// Server is the bridge's local authorizer. It answers envoy's authorize
+// requests from the target's intentions or by asking the agent, applying the
+// configured timeout and failure policy.
+type Server struct {
+	ctx        context.Context
+	agent      AgentClient
+	intentions IntentionsClient
+
+	lock     sync.RWMutex
+	settings Settings
+	ixnCache *intentionCache
+	auditLog *AuditLog
+	changes  chan struct{}
+}
+
+// intentions are watched until ctx is cancelled
+func NewServer(ctx context.Context, agent AgentClient, intentions IntentionsClient) *Server {
+	return &Server{
+		ctx:        ctx,
+		agent:      agent,
+		intentions: intentions,
+		settings: Settings{
+			Mode:          ModeAgent,
+			FailurePolicy: FailClosed,
+		},
+		changes: make(chan struct{}, 1),
+	}
+}
+
+func (s *Server) SetSettings(settings Settings) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.settings = settings
+	if s.ixnCache != nil && (settings.Mode != ModeIntentions || s.ixnCache.target != settings.Target) {
+		s.ixnCache.stop()
+		s.ixnCache = nil
+	}
+	if settings.Mode == ModeIntentions && s.ixnCache == nil {
+		s.ixnCache = watchIntentions(s.ctx, s.intentions, s.agent, settings.Target, s.changes)
+	}
+}
+
+// Intentions returns the watched intentions for target, sorted by precedence,
+// and whether connections that match none of them are allowed. ok is false
+// if they are not watched or not synced.
+func (s *Server) Intentions(target string) (intentions []*api.Intention, defaultAllow bool, ok bool) {
+	s.lock.RLock()
+	cache := s.ixnCache
+	s.lock.RUnlock()
+	if cache == nil || cache.target != target {
+		return nil, false, false
+	}
+	return cache.get()
+}
+
+// IntentionChanges is signalled when the watched intentions changed, or
+// stopped being synced
+func (s *Server) IntentionChanges() <-chan struct{} {
+	return s.changes
+}
+
+func (s *Server) Settings() Settings {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.settings
+}
+
+// SetAuditLog makes the server record every decision; nil disables auditing
+func (s *Server) SetAuditLog(auditLog *AuditLog) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.auditLog = auditLog
+}
+
+func (s *Server) AuditLog() *AuditLog {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.auditLog
+}
+
+// Serve serves authorize requests on the listener until the context is cancelled
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc(Path, s.handleAuthorize)
+	srv := &http.Server{Handler: mux}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	if err := srv.Serve(listener); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+func (s *Server) handleAuthorize(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var params api.AgentAuthorizeParams
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(s.Authorize(&params))
+}
+
+// decision records how an authorize response was reached, for auditing
+type decision struct {
+	decidedBy string
+	intention string
+}
+
+func (s *Server) Authorize(params *api.AgentAuthorizeParams) *api.AgentAuthorize {
+	start := time.Now()
+	resp, d := s.decide(params)
+	if auditLog := s.AuditLog(); auditLog != nil {
+		if err := auditLog.Write(newAuditRecord(params, resp, d, time.Since(start))); err != nil {
+			log.Warnf("failed to write authorize audit record: %v", err)
+		}
+	}
+	return resp
+}
+
+func (s *Server) decide(params *api.AgentAuthorizeParams) (*api.AgentAuthorize, decision) {
+	if resp, d, ok := s.authorizeFromIntentions(params); ok {
+		return resp, d
+	}
+	settings := s.Settings()
+	resp, err := s.callAgent(params, settings.Timeout)
+	if err == nil {
+		return resp, decision{decidedBy: decidedByAgent}
+	}
+	log.Warnf("failed to authorize %v to %v: %v", params.ClientCertURI, params.Target, err)
+	if settings.FailurePolicy == FailOpen && agentUnavailable(err) {
+		return &api.AgentAuthorize{
+			Authorized: true,
+			Reason:     "agent unavailable, failing open: " + err.Error(),
+		}, decision{decidedBy: decidedByFailurePolicy}
+	}
+	return &api.AgentAuthorize{
+		Authorized: false,
+		Reason:     err.Error(),
+	}, decision{decidedBy: decidedByFailurePolicy}
+}
+
+// authorizeFromIntentions returns false if the decision has to be left to the agent
+func (s *Server) authorizeFromIntentions(params *api.AgentAuthorizeParams) (*api.AgentAuthorize, decision, bool) {
+	s.lock.RLock()
+	cache := s.ixnCache
+	s.lock.RUnlock()
+	if cache == nil || cache.target != params.Target {
+		return nil, decision{}, false
+	}
+	ns, service, err := parseServiceURI(params.ClientCertURI)
+	if err != nil {
+		return &api.AgentAuthorize{
+			Authorized: false,
+			Reason:     err.Error(),
+		}, decision{decidedBy: decidedByIntentions}, true
+	}
+	ixn, ok := cache.match(ns, service)
+	if !ok || ixn == nil {
+		return nil, decision{}, false
+	}
+	return &api.AgentAuthorize{
+		Authorized: ixn.Action == api.IntentionActionAllow,
+		Reason:     intentionReason(ixn),
+	}, decision{decidedBy: decidedByIntentions, intention: ixn.ID}, true
+}
+
+func (s *Server) callAgent(params *api.AgentAuthorizeParams, timeout time.Duration) (*api.AgentAuthorize, error) {
+	if timeout <= 0 {
+		return s.agent.ConnectAuthorize(params)
+	}
+	type result struct {
+		resp *api.AgentAuthorize
+		err  error
+	}
+	results := make(chan result, 1)
+	go func() {
+		resp, err := s.agent.ConnectAuthorize(params)
+		results <- result{resp: resp, err: err}
+	}()
+	select {
+	case res := <-results:
+		return res.resp, res.err
+	case <-time.After(timeout):
+		return nil, errors.Errorf("agent did not respond within %v", timeout)
+	}
+}
+
+// agentUnavailable returns false if the agent answered with a client error
+// (e.g. a malformed certificate uri or a missing acl permission). those are
+// never failed open.
+func agentUnavailable(err error) bool {
+	if statusErr, ok := errors.Cause(err).(*StatusError); ok {
+		return statusErr.StatusCode >= http.StatusInternalServerError
+	}
+	return true
+}
@@ -0,0 +1,210 @@
+package authorize_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/solo-io/gloo-connect/pkg/authorize"
+)
+
+type fakeAgent struct {
+	delay time.Duration
+	resp  *api.AgentAuthorize
+	err   error
+}
+
+func (a *fakeAgent) ConnectAuthorize(auth *api.AgentAuthorizeParams) (*api.AgentAuthorize, error) {
+	time.Sleep(a.delay)
+	return a.resp, a.err
+}
+
+func (a *fakeAgent) IntentionDefaultAllow() (bool, error) {
+	return false, nil
+}
+
+type fakeIntentions struct {
+	matches chan map[string][]*api.Intention
+	errs    chan error
+	index   uint64
+}
+
+func (i *fakeIntentions) IntentionMatch(args *api.IntentionMatch, q *api.QueryOptions) (map[string][]*api.Intention, *api.QueryMeta, error) {
+	select {
+	case matches := <-i.matches:
+		i.index++
+		return matches, &api.QueryMeta{LastIndex: i.index}, nil
+	case err := <-i.errs:
+		return nil, nil, err
+	case <-q.Context().Done():
+		return nil, nil, q.Context().Err()
+	}
+}
+
+var _ = Describe("Server", func() {
+	var (
+		ctx        context.Context
+		cancel     context.CancelFunc
+		agent      *fakeAgent
+		intentions *fakeIntentions
+		server     *Server
+		params     *api.AgentAuthorizeParams
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithCancel(context.Background())
+		agent = &fakeAgent{resp: &api.AgentAuthorize{Authorized: true}}
+		intentions = &fakeIntentions{
+			matches: make(chan map[string][]*api.Intention, 10),
+			errs:    make(chan error, 10),
+		}
+		server = NewServer(ctx, agent, intentions)
+		params = &api.AgentAuthorizeParams{
+			Target:        "web",
+			ClientCertURI: "spiffe://11111111-2222-3333-4444-555555555555.consul/ns/default/dc/dc1/svc/test",
+		}
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	It("returns the agent's decision", func() {
+		agent.resp = &api.AgentAuthorize{Authorized: false, Reason: "denied by intention"}
+		Expect(server.Authorize(params).Authorized).To(BeFalse())
+	})
+
+	It("fails closed when the agent times out", func() {
+		agent.delay = time.Second
+		server.SetSettings(Settings{Timeout: 10 * time.Millisecond, FailurePolicy: FailClosed})
+		Expect(server.Authorize(params).Authorized).To(BeFalse())
+	})
+
+	It("fails open when the agent times out", func() {
+		agent.delay = time.Second
+		server.SetSettings(Settings{Timeout: 10 * time.Millisecond, FailurePolicy: FailOpen})
+		Expect(server.Authorize(params).Authorized).To(BeTrue())
+	})
+
+	It("fails open when the agent can't be reached", func() {
+		agent.err = errors.New("dial tcp 127.0.0.1:8500: connect: connection refused")
+		server.SetSettings(Settings{FailurePolicy: FailOpen})
+		Expect(server.Authorize(params).Authorized).To(BeTrue())
+	})
+
+	It("fails open when the agent fails", func() {
+		agent.err = &StatusError{StatusCode: 500, Body: "rpc error"}
+		server.SetSettings(Settings{FailurePolicy: FailOpen})
+		Expect(server.Authorize(params).Authorized).To(BeTrue())
+	})
+
+	It("never fails open on client errors", func() {
+		agent.err = &StatusError{StatusCode: 400, Body: "invalid client cert uri"}
+		server.SetSettings(Settings{FailurePolicy: FailOpen})
+		Expect(server.Authorize(params).Authorized).To(BeFalse())
+	})
+
+	Context("intentions mode", func() {
+		BeforeEach(func() {
+			server.SetSettings(Settings{Target: "web", Mode: ModeIntentions, FailurePolicy: FailClosed})
+		})
+
+		intention := func(source string, action api.IntentionAction) *api.Intention {
+			return &api.Intention{
+				ID:              source + "-to-web",
+				SourceNS:        "default",
+				SourceName:      source,
+				DestinationNS:   "default",
+				DestinationName: "web",
+				Action:          action,
+			}
+		}
+
+		It("asks the agent until intentions are synced", func() {
+			Expect(server.Authorize(params).Authorized).To(BeTrue())
+		})
+
+		It("decides from the matching intention", func() {
+			intentions.matches <- map[string][]*api.Intention{
+				"web": {intention("test", api.IntentionActionDeny), intention("*", api.IntentionActionAllow)},
+			}
+			Eventually(func() bool { return server.Authorize(params).Authorized }).Should(BeFalse())
+		})
+
+		It("picks up intention changes", func() {
+			intentions.matches <- map[string][]*api.Intention{
+				"web": {intention("test", api.IntentionActionDeny)},
+			}
+			Eventually(func() bool { return server.Authorize(params).Authorized }).Should(BeFalse())
+			intentions.matches <- map[string][]*api.Intention{
+				"web": {intention("test", api.IntentionActionAllow)},
+			}
+			Eventually(func() bool { return server.Authorize(params).Authorized }).Should(BeTrue())
+		})
+
+		It("asks the agent when no intention matches", func() {
+			intentions.matches <- map[string][]*api.Intention{
+				"web": {intention("other", api.IntentionActionAllow)},
+			}
+			agent.resp = &api.AgentAuthorize{Authorized: false, Reason: "default deny"}
+			Eventually(func() string { return server.Authorize(params).Reason }).Should(Equal("default deny"))
+		})
+
+		It("signals intention changes", func() {
+			intentions.matches <- map[string][]*api.Intention{
+				"web": {intention("test", api.IntentionActionDeny)},
+			}
+			Eventually(server.IntentionChanges()).Should(Receive())
+			ixns, _, ok := server.Intentions("web")
+			Expect(ok).To(BeTrue())
+			Expect(ixns).To(HaveLen(1))
+		})
+
+		It("asks the agent again when the intentions can't be watched", func() {
+			intentions.matches <- map[string][]*api.Intention{
+				"web": {intention("test", api.IntentionActionDeny)},
+			}
+			Eventually(func() bool { return server.Authorize(params).Authorized }).Should(BeFalse())
+			for i := 0; i < 3; i++ {
+				intentions.errs <- errors.New("connection refused")
+			}
+			Eventually(func() bool { return server.Authorize(params).Authorized }, 5*time.Second).Should(BeTrue())
+			_, _, ok := server.Intentions("web")
+			Expect(ok).To(BeFalse())
+		})
+
+		It("denies invalid client identities", func() {
+			intentions.matches <- map[string][]*api.Intention{
+				"web": {intention("*", api.IntentionActionAllow)},
+			}
+			params.ClientCertURI = "spiffe://example.org/not-a-service"
+			Eventually(func() bool { return server.Authorize(params).Authorized }).Should(BeFalse())
+		})
+	})
+
+	It("writes an audit record for every decision", func() {
+		var buf bytes.Buffer
+		server.SetAuditLog(NewAuditLog(&buf))
+		agent.resp = &api.AgentAuthorize{
+			Authorized: false,
+			Reason:     "Matched intention: default/test => default/web (ID: 8a2ee8d6-1f0a-4c5a-b6a8-0c1d4c9b5f3e, Precedence: 9)",
+		}
+		server.Authorize(params)
+
+		var record AuditRecord
+		err := json.Unmarshal(buf.Bytes(), &record)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(record.Source).To(Equal(params.ClientCertURI))
+		Expect(record.Destination).To(Equal("web"))
+		Expect(record.Decision).To(Equal("deny"))
+		Expect(record.DecidedBy).To(Equal("agent"))
+		Expect(record.Intention).To(Equal("8a2ee8d6-1f0a-4c5a-b6a8-0c1d4c9b5f3e"))
+	})
+})
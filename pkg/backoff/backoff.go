@@ -0,0 +1,42 @@
+package backoff
+
+import "time"
+
+const (
+	DefaultInitial = time.Second
+	DefaultMax     = 30 * time.Second
+)
+
+// Backoff doubles the delay after every consecutive failure, up to Max.
+// The zero value uses the defaults.
+type Backoff struct {
+	Initial time.Duration
+	Max     time.Duration
+
+	next time.Duration
+}
+
+// Next returns how long to wait before the next retry
+func (b *Backoff) Next() time.Duration {
+	initial, max := b.Initial, b.Max
+	if initial == 0 {
+		initial = DefaultInitial
+	}
+	if max == 0 {
+		max = DefaultMax
+	}
+	if b.next == 0 {
+		b.next = initial
+	}
+	delay := b.next
+	b.next *= 2
+	if b.next > max {
+		b.next = max
+	}
+	return delay
+}
+
+// Reset starts over after a success
+func (b *Backoff) Reset() {
+	b.next = 0
+}
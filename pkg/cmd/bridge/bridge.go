@@ -1,6 +1,9 @@
 package bridge
 
 import (
+	"time"
+
+	"github.com/solo-io/gloo-connect/pkg/debug"
 	"github.com/solo-io/gloo-connect/pkg/runner"
 	"github.com/solo-io/gloo/pkg/bootstrap/configstorage"
 	"github.com/spf13/cobra"
@@ -20,6 +23,16 @@ func Cmd(rc *runner.RunConfig) *cobra.Command {
 	cmd.PersistentFlags().BoolVar(&rc.UseUDS, "gloo-uds", false, "use unix domain socket for gloo and envoy")
 	cmd.PersistentFlags().StringVar(&rc.ConfigDir, "conf-dir", "", "config dir to hold envoy config file")
 	cmd.PersistentFlags().StringVar(&rc.EnvoyPath, "envoy-path", "", "path to envoy binary")
+	cmd.PersistentFlags().StringVar(&rc.AuthorizeAuditLog, "authorize-audit-log", "", "file to write an audit record of every inbound authorization decision to, \"-\" for stdout")
+	cmd.PersistentFlags().BoolVar(&rc.HealthGating, "health-gating", false, "stop accepting inbound traffic while the consul health checks of the target service instance fail")
+	cmd.PersistentFlags().UintVar(&rc.EnvoyAdminPort, "envoy-admin-port", 0, "loopback port for envoy's admin api; 0 picks a free port")
+	cmd.PersistentFlags().DurationVar(&rc.SelfCheckTTL, "self-check-ttl", 30*time.Second, "ttl of the check the bridge registers on the proxy service; 0 disables the check")
+	cmd.PersistentFlags().DurationVar(&rc.DrainTime, "drain-time", 5*time.Second, "how long envoy drains connections when listeners are removed or the proxy is deregistered")
+	cmd.PersistentFlags().IntVar(&rc.MaxSyncFailures, "max-sync-failures", 0, "exit after this many consecutive failures to sync the role or update the certificates; 0 never exits")
+	cmd.PersistentFlags().DurationVar(&rc.SyncQuietPeriod, "sync-quiet-period", 200*time.Millisecond, "wait for certificate and proxy config changes to settle this long before applying them; 0 applies them right away")
+	cmd.PersistentFlags().DurationVar(&rc.SyncMaxDelay, "sync-max-delay", 2*time.Second, "apply certificate and proxy config changes at the latest this long after the first one, even if more keep coming")
+	cmd.PersistentFlags().StringVar(&rc.DebugAddress, "debug-address", "", "loopback address to serve the debug endpoints on, e.g. "+debug.DefaultAddress+"; empty disables them")
+	cmd.PersistentFlags().BoolVar(&rc.DiscoverAllServices, "discover-all-services", false, "discover every service in the consul catalog, instead of only the proxy's upstreams and the destinations of its policies")
 	return cmd
 }
 
@@ -0,0 +1,147 @@
+package debug
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/solo-io/gloo-connect/pkg/debug"
+	"github.com/spf13/cobra"
+)
+
+func cmdBundle(address *string) *cobra.Command {
+	var output string
+	cmd := &cobra.Command{
+		Use:   "bundle",
+		Short: "collect the diagnostics of a bridge and its envoy into a tarball for support",
+		RunE: func(c *cobra.Command, args []string) error {
+			if output == "" {
+				output = fmt.Sprintf("gloo-connect-bundle-%s.tar.gz", time.Now().Format("20060102-150405"))
+			}
+			if err := writeBundle(*address, output); err != nil {
+				return err
+			}
+			fmt.Printf("wrote %v\n", output)
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", "", "file to write the bundle to; defaults to gloo-connect-bundle-<time>.tar.gz")
+	return cmd
+}
+
+// bundleFile is a file of the bundle. a file that can't be collected is
+// replaced by <name>.error, so one failure doesn't lose the rest.
+type bundleFile struct {
+	name    string
+	collect func() ([]byte, error)
+}
+
+func writeBundle(address, output string) error {
+	// the dump has the bootstrap and the proxy config, which get their own files
+	dump, dumpErr := get(address, debug.DumpPath)
+	fromDump := func(field string) func() ([]byte, error) {
+		return func() ([]byte, error) {
+			if dumpErr != nil {
+				return nil, dumpErr
+			}
+			var fields map[string]json.RawMessage
+			if err := json.Unmarshal(dump, &fields); err != nil {
+				return nil, err
+			}
+			raw, ok := fields[field]
+			if !ok {
+				return nil, errors.Errorf("the bridge has no %v yet", field)
+			}
+			return indent(raw)
+		}
+	}
+	fromBridge := func(path string) func() ([]byte, error) {
+		return func() ([]byte, error) { return get(address, path) }
+	}
+
+	files := []bundleFile{
+		{"status.json", fromBridge(debug.StatusPath)},
+		{"dump.json", func() ([]byte, error) { return dump, dumpErr }},
+		{"envoy-bootstrap.json", fromDump("envoy_bootstrap")},
+		{"proxy-config.json", fromDump("proxy_config")},
+		{"certificates.json", fromBridge(debug.CertificatesPath)},
+		{"bridge.log", fromBridge(debug.LogsPath)},
+		{"envoy.log", fromBridge(debug.EnvoyLogsPath)},
+	}
+	for _, p := range debug.EnvoyAdminPaths {
+		name := path.Join("envoy", p+".json")
+		if p == "clusters" || p == "stats" {
+			name = path.Join("envoy", p+".txt")
+		}
+		files = append(files, bundleFile{name, fromBridge(debug.EnvoyAdminPath + p)})
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	dir := "gloo-connect-bundle"
+	now := time.Now()
+	var failed int
+	for _, f := range files {
+		name := f.name
+		data, err := f.collect()
+		if err != nil {
+			failed++
+			name, data = name+".error", []byte(err.Error()+"\n")
+		}
+		hdr := &tar.Header{
+			Name:     path.Join(dir, name),
+			Typeflag: tar.TypeReg,
+			Mode:     0644,
+			Size:     int64(len(data)),
+			ModTime:  now,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	if failed == len(files) {
+		return errors.Errorf("could not collect anything from the bridge at %v: %v", address, dumpErr)
+	}
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "%v of %v files could not be collected; see the .error files in the bundle\n", failed, len(files))
+	}
+	// private keys are redacted, but the config is still nobody else's business
+	return errors.Wrapf(writeFile(output, buf.Bytes()), "writing %v", output)
+}
+
+func writeFile(name string, data []byte) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func indent(raw []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, raw, "", "  "); err != nil {
+		return nil, err
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,55 @@
+package debug
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/solo-io/gloo-connect/pkg/debug"
+	"github.com/spf13/cobra"
+)
+
+func Cmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "debug",
+		Short: "inspect a running bridge through its debug endpoints",
+	}
+	var address string
+	cmd.PersistentFlags().StringVar(&address, "debug-address", debug.DefaultAddress, "debug address of the bridge, as set with bridge --debug-address")
+	cmd.AddCommand(cmdDump(&address), cmdBundle(&address))
+	return cmd
+}
+
+func cmdDump(address *string) *cobra.Command {
+	return &cobra.Command{
+		Use:   "dump",
+		Short: "print the bridge's in-memory config and secrets, the envoy bootstrap and the latest proxy config as json",
+		RunE: func(c *cobra.Command, args []string) error {
+			dump, err := get(*address, debug.DumpPath)
+			if err != nil {
+				return err
+			}
+			_, err = os.Stdout.Write(dump)
+			return err
+		},
+	}
+}
+
+func get(address, path string) ([]byte, error) {
+	resp, err := http.Get(fmt.Sprintf("http://%s%s", address, path))
+	if err != nil {
+		return nil, errors.Wrapf(err, "is the bridge running with --debug-address %v?", address)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("%v returned %v: %s", path, resp.Status, bytes.TrimSpace(body))
+	}
+	return body, nil
+}
@@ -9,9 +9,9 @@ import (
 func Cmd(rc *runner.RunConfig) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "get",
-		Short: "get services available over http",
+		Short: "get services available over http, and the policies set for them",
 	}
-	cmd.AddCommand(cmdGetServices(rc))
+	cmd.AddCommand(cmdGetServices(rc), cmdGetPolicies(rc), cmdGetRoutes(rc))
 	return cmd
 }
 
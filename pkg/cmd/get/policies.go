@@ -0,0 +1,130 @@
+package get
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+	"github.com/solo-io/gloo-connect/pkg/cmd/glooclient"
+	"github.com/solo-io/gloo-connect/pkg/runner"
+	"github.com/solo-io/gloo/pkg/bootstrap/configstorage"
+	"github.com/spf13/cobra"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputYAML  = "yaml"
+)
+
+func cmdGetPolicies(rc *runner.RunConfig) *cobra.Command {
+	var output string
+	cmd := &cobra.Command{
+		Use:   "policies [service]",
+		Short: "get the policies set with set service, optionally only those from or to a service",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(c *cobra.Command, args []string) error {
+			policies, err := listPolicies(rc, args)
+			if err != nil {
+				return err
+			}
+			return printOutput(os.Stdout, output, policies, func(w io.Writer) {
+				fmt.Fprintln(w, "ORIGIN\tDESTINATION\tROUTES\tNAME")
+				for _, p := range policies {
+					routes := fmt.Sprint(len(p.Routes))
+					switch {
+					case p.Missing:
+						routes = "missing"
+					case p.Error != "":
+						routes += " (error)"
+					}
+					fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", p.Origin, p.Destination, routes, p.Name)
+				}
+			})
+		},
+	}
+	addOutputFlag(cmd, &output)
+	return cmd
+}
+
+func cmdGetRoutes(rc *runner.RunConfig) *cobra.Command {
+	var output string
+	cmd := &cobra.Command{
+		Use:   "routes [service]",
+		Short: "get the routes of the policies set with set service, optionally only those from or to a service",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(c *cobra.Command, args []string) error {
+			policies, err := listPolicies(rc, args)
+			if err != nil {
+				return err
+			}
+			routes := []glooclient.PolicyRoute{}
+			for _, p := range policies {
+				if p.Error != "" {
+					fmt.Fprintf(os.Stderr, "policy %v: %v\n", p.Name, p.Error)
+				}
+				routes = append(routes, p.Routes...)
+			}
+			return printOutput(os.Stdout, output, routes, func(w io.Writer) {
+				fmt.Fprintln(w, "ORIGIN\tDESTINATION\tMATCHER\tRETRIES\tTIMEOUT\tEXTENSIONS")
+				for _, r := range routes {
+					fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\n", r.Origin, r.Destination, r.Matcher,
+						orNone(fmt.Sprint(r.Retries), "0"), orNone(r.Timeout, ""), orNone(strings.Join(r.OtherExtensions(), ","), ""))
+				}
+			})
+		},
+	}
+	addOutputFlag(cmd, &output)
+	return cmd
+}
+
+func listPolicies(rc *runner.RunConfig, args []string) ([]glooclient.Policy, error) {
+	store, err := configstorage.Bootstrap(rc.Options)
+	if err != nil {
+		return nil, err
+	}
+	gc := glooclient.GlooClient{Store: store}
+	var service string
+	if len(args) > 0 {
+		service = args[0]
+	}
+	return gc.ListPolicies(service)
+}
+
+func addOutputFlag(cmd *cobra.Command, output *string) {
+	cmd.Flags().StringVarP(output, "output", "o", outputTable, "output format: table, json or yaml")
+}
+
+func orNone(value, empty string) string {
+	if value == empty {
+		return "-"
+	}
+	return value
+}
+
+// printOutput writes v as json or yaml, or calls table with a tabwriter
+func printOutput(out io.Writer, format string, v interface{}, table func(w io.Writer)) error {
+	switch format {
+	case outputTable:
+		w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+		table(w)
+		return w.Flush()
+	case outputJSON:
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case outputYAML:
+		data, err := yaml.Marshal(v)
+		if err != nil {
+			return err
+		}
+		_, err = out.Write(data)
+		return err
+	}
+	return errors.Errorf("unknown output format %q, must be one of table, json or yaml", format)
+}
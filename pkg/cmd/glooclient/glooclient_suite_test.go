@@ -0,0 +1,13 @@
+package glooclient_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestGlooclient(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Glooclient Suite")
+}
@@ -0,0 +1,271 @@
+package glooclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gogo/protobuf/jsonpb"
+	"github.com/gogo/protobuf/types"
+	"github.com/pkg/errors"
+	"github.com/solo-io/gloo-connect/pkg/policy"
+	"github.com/solo-io/gloo/pkg/api/types/v1"
+	"github.com/solo-io/gloo/pkg/coreplugins/route-extensions"
+)
+
+// Policy is what `set service` wrote for the requests from an origin to a destination
+type Policy struct {
+	Name        string        `json:"name"`
+	Origin      string        `json:"origin"`
+	Destination string        `json:"destination"`
+	Routes      []PolicyRoute `json:"routes"`
+	// the attribute's virtual service is gone, e.g. it was deleted by hand
+	Missing bool `json:"missing,omitempty"`
+	// why some of the routes couldn't be read; those are left out
+	Error string `json:"error,omitempty"`
+}
+
+type PolicyRoute struct {
+	Origin      string  `json:"origin"`
+	Destination string  `json:"destination"`
+	Matcher     Matcher `json:"matcher"`
+	Retries     uint32  `json:"retries,omitempty"`
+	Timeout     string  `json:"timeout,omitempty"`
+	// all route extensions, including retries and timeout
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+}
+
+type Matcher struct {
+	PathExact  string            `json:"path_exact,omitempty"`
+	PathPrefix string            `json:"path_prefix,omitempty"`
+	PathRegex  string            `json:"path_regex,omitempty"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	Query      map[string]string `json:"query,omitempty"`
+	Methods    []string          `json:"methods,omitempty"`
+}
+
+func (m Matcher) String() string {
+	var parts []string
+	switch {
+	case m.PathExact != "":
+		parts = append(parts, "exact:"+m.PathExact)
+	case m.PathRegex != "":
+		parts = append(parts, "regex:"+m.PathRegex)
+	default:
+		parts = append(parts, "prefix:"+m.PathPrefix)
+	}
+	parts = append(parts, keyValues("header", m.Headers)...)
+	parts = append(parts, keyValues("query", m.Query)...)
+	if len(m.Methods) > 0 {
+		parts = append(parts, "method:"+strings.Join(m.Methods, ","))
+	}
+	return strings.Join(parts, " ")
+}
+
+func keyValues(kind string, values map[string]string) []string {
+	var out []string
+	for k, v := range values {
+		out = append(out, fmt.Sprintf("%v:%v=%v", kind, k, v))
+	}
+	sort.Strings(out)
+	return out
+}
+
+// ListPolicies returns the policies that have service as origin or
+// destination, or all of them if service is empty
+func (c *GlooClient) ListPolicies(service string) ([]Policy, error) {
+	attributes, err := c.Store.V1().Attributes().List()
+	if err != nil {
+		return nil, errors.Wrap(err, "listing attributes")
+	}
+	policies := []Policy{}
+	for _, attribute := range attributes {
+		listenerAttribute := attribute.GetListenerAttribute()
+		if listenerAttribute == nil {
+			continue
+		}
+		destination, ok := listenerAttribute.Selector[policy.LabelDestination]
+		if !ok {
+			// not written by set service
+			continue
+		}
+		origin := policy.AllOrigins
+		if o, ok := listenerAttribute.Selector[policy.LabelService]; ok {
+			origin = o
+		}
+		if attribute.Name != policy.Name(origin, destination) {
+			// not written by set service either
+			continue
+		}
+		if service != "" && service != origin && service != destination {
+			continue
+		}
+		for _, name := range listenerAttribute.VirtualServices {
+			p := Policy{
+				Name:        name,
+				Origin:      origin,
+				Destination: destination,
+				Routes:      []PolicyRoute{},
+			}
+			vService, err := c.Store.V1().VirtualServices().Get(name)
+			if err != nil {
+				p.Missing = true
+				policies = append(policies, p)
+				continue
+			}
+			var errs []string
+			for _, route := range vService.Routes {
+				policyRoute, err := describeRoute(origin, destination, route)
+				if err != nil {
+					errs = append(errs, fmt.Sprintf("route %v: %v", describeMatcher(route.GetRequestMatcher()), err))
+					continue
+				}
+				p.Routes = append(p.Routes, policyRoute)
+			}
+			p.Error = strings.Join(errs, "; ")
+			policies = append(policies, p)
+		}
+	}
+	sort.SliceStable(policies, func(i, j int) bool {
+		if policies[i].Destination != policies[j].Destination {
+			return policies[i].Destination < policies[j].Destination
+		}
+		return policies[i].Origin < policies[j].Origin
+	})
+	return policies, nil
+}
+
+// MigrateLegacyPolicies rewrites the selectors of the policies written before
+// origins had precedence, and returns their names. Those lack the policy
+// label, so they apply to every listener of their destination. Bridges older
+// than the policy label don't get the rewritten policies, so this only runs
+// once every bridge is upgraded.
+func (c *GlooClient) MigrateLegacyPolicies() ([]string, error) {
+	attributes, err := c.Store.V1().Attributes().List()
+	if err != nil {
+		return nil, errors.Wrap(err, "listing attributes")
+	}
+	var migrated []string
+	for _, attribute := range attributes {
+		listenerAttribute := attribute.GetListenerAttribute()
+		if listenerAttribute == nil {
+			continue
+		}
+		origin, destination, ok := legacySelector(listenerAttribute.Selector)
+		// only touch the policies the cli wrote
+		if !ok || attribute.Name != policy.Name(origin, destination) {
+			continue
+		}
+		listenerAttribute.Selector = policy.Selector(origin, destination)
+		if _, err := c.Store.V1().Attributes().Update(attribute); err != nil {
+			return migrated, errors.Wrapf(err, "migrating policy %v", attribute.Name)
+		}
+		migrated = append(migrated, attribute.Name)
+	}
+	return migrated, nil
+}
+
+// legacySelector returns the origin and destination of a selector written
+// before the policy label existed
+func legacySelector(selector map[string]string) (string, string, bool) {
+	destination, ok := selector[policy.LabelDestination]
+	if !ok {
+		return "", "", false
+	}
+	if _, ok := selector[policy.LabelPolicy]; ok {
+		return "", "", false
+	}
+	switch len(selector) {
+	case 1:
+		return policy.AllOrigins, destination, true
+	case 2:
+		origin, ok := selector[policy.LabelService]
+		return origin, destination, ok
+	}
+	return "", "", false
+}
+
+func describeRoute(origin, destination string, route *v1.Route) (PolicyRoute, error) {
+	out := PolicyRoute{
+		Origin:      origin,
+		Destination: destination,
+		Matcher:     describeMatcher(route.GetRequestMatcher()),
+	}
+	if route.Extensions == nil {
+		return out, nil
+	}
+	spec, err := extensions.DecodeRouteExtensionSpec(route.Extensions)
+	if err != nil {
+		return out, err
+	}
+	out.Retries = spec.MaxRetries
+	if spec.Timeout > 0 {
+		out.Timeout = spec.Timeout.String()
+	}
+	out.Extensions, err = structToMap(route.Extensions)
+	return out, err
+}
+
+func describeMatcher(m *v1.RequestMatcher) Matcher {
+	if m == nil {
+		return Matcher{}
+	}
+	return Matcher{
+		PathExact:  m.GetPathExact(),
+		PathPrefix: m.GetPathPrefix(),
+		PathRegex:  m.GetPathRegex(),
+		Headers:    m.Headers,
+		Query:      m.QueryParams,
+		Methods:    m.Verbs,
+	}
+}
+
+func structToMap(s *types.Struct) (map[string]interface{}, error) {
+	var buf bytes.Buffer
+	if err := (&jsonpb.Marshaler{}).Marshal(&buf, s); err != nil {
+		return nil, err
+	}
+	var m map[string]interface{}
+	err := json.Unmarshal(buf.Bytes(), &m)
+	return m, err
+}
+
+// the json names of RouteExtensionSpec.MaxRetries and Timeout, which have
+// columns of their own
+var retriesAndTimeout = map[string]bool{
+	"max_retries": true,
+	"timeout":     true,
+}
+
+// OtherExtensions returns the names of the route's extensions besides retries and timeout
+func (r PolicyRoute) OtherExtensions() []string {
+	var other []string
+	for name, value := range r.Extensions {
+		if isEmpty(value) || retriesAndTimeout[name] {
+			continue
+		}
+		other = append(other, name)
+	}
+	sort.Strings(other)
+	return other
+}
+
+func isEmpty(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case bool:
+		return !v
+	case float64:
+		return v == 0
+	case string:
+		return v == ""
+	case []interface{}:
+		return len(v) == 0
+	case map[string]interface{}:
+		return len(v) == 0
+	}
+	return false
+}
@@ -1,13 +1,15 @@
 package glooclient
 
 import (
-	"fmt"
+	"sort"
 
 	"github.com/solo-io/gloo/pkg/plugins/consul"
 
 	"time"
 
 	"github.com/gogo/protobuf/types"
+	"github.com/pkg/errors"
+	"github.com/solo-io/gloo-connect/pkg/policy"
 	"github.com/solo-io/gloo/pkg/api/types/v1"
 	"github.com/solo-io/gloo/pkg/storage"
 
@@ -33,18 +35,24 @@ type Route struct {
 	// Destination is implicit
 }
 
-const (
-	allOrigins = "all-origins"
-)
-
-func (c *GlooClient) ConfigureService(serviceType string, retries uint32, timeout time.Duration) error {
+// ConfigureService applies retries and a timeout to the requests from origin
+// to the service that match the matcher; leave origin empty for all origins.
+// Requests that match no policy are still routed to the service.
+func (c *GlooClient) ConfigureService(origin, serviceType string, matcher *v1.RequestMatcher, retries uint32, timeout time.Duration) error {
 	res := extensions.RouteExtensionSpec{
 		MaxRetries: retries,
 	}
 	if timeout > 0 {
 		res.Timeout = timeout
 	}
-	return c.EnableBasicHttp("", serviceType, extensions.EncodeRouteExtensionSpec(res))
+	route := Route{
+		Matcher: matcher,
+		Config:  extensions.EncodeRouteExtensionSpec(res),
+	}
+	return c.updateRoutes(origin, serviceType, func(routes []*v1.Route) []*v1.Route {
+		routes = mergeRoute(routes, serviceType, route)
+		return withCatchAll(routes, serviceType)
+	})
 }
 
 func (c *GlooClient) Demo() error {
@@ -54,38 +62,151 @@ func (c *GlooClient) Demo() error {
 	}))
 }
 
+// catchAll matches all requests
+var catchAll = &v1.RequestMatcher{
+	Path: &v1.RequestMatcher_PathPrefix{
+		PathPrefix: "/",
+	},
+}
+
 func (c *GlooClient) EnableBasicHttp(origin, destination string, config *types.Struct) error {
 	return c.AddRoute(origin, destination, Route{
-		Matcher: &v1.RequestMatcher{
-			Path: &v1.RequestMatcher_PathPrefix{
-				PathPrefix: "/",
-			},
-		},
-		Config: config,
+		Matcher: catchAll,
+		Config:  config,
 	})
 }
 
+// AddRoute adds a route from origin to destination, or replaces the route
+// with the same matcher. Leave origin empty to apply the route to all origins.
 // Currently routes are only supported on outbound listeners
 // TODO(ilackarms): modify here and connect/plugin.go to support both ways
 func (c *GlooClient) AddRoute(origin, destination string, route Route) error {
-	if origin == "" {
-		origin = allOrigins
+	return c.updateRoutes(origin, destination, func(routes []*v1.Route) []*v1.Route {
+		return mergeRoute(routes, destination, route)
+	})
+}
+
+// ReplaceRoutes replaces all routes from origin to destination. Without
+// routes, the policy is deleted.
+func (c *GlooClient) ReplaceRoutes(origin, destination string, routes []Route) error {
+	if len(routes) == 0 {
+		return c.DeletePolicy(origin, destination)
 	}
-	name := virtualServiceName(origin, destination)
-	vService, err := c.Store.V1().VirtualServices().Get(name)
+	return c.updateRoutes(origin, destination, func([]*v1.Route) []*v1.Route {
+		var replaced []*v1.Route
+		for _, route := range routes {
+			replaced = append(replaced, glooRoute(destination, route))
+		}
+		return replaced
+	})
+}
+
+// RemoveRoute removes the route with the given matcher from origin to
+// destination, and the policy once it has no routes left
+func (c *GlooClient) RemoveRoute(origin, destination string, matcher *v1.RequestMatcher) error {
+	return c.removeRoute(origin, destination, matcher, func(routes []*v1.Route) []*v1.Route {
+		return routes
+	})
+}
+
+// UnsetService removes the policy from origin to the service, or only its route
+// with the given matcher if matcher isn't nil; leave origin empty for all
+// origins. Requests that match no policy left are still routed to the service.
+func (c *GlooClient) UnsetService(origin, serviceType string, matcher *v1.RequestMatcher) error {
+	if matcher == nil {
+		return c.DeletePolicy(origin, serviceType)
+	}
+	return c.removeRoute(origin, serviceType, matcher, func(routes []*v1.Route) []*v1.Route {
+		for _, route := range routes {
+			if route.Extensions != nil {
+				return withCatchAll(routes, serviceType)
+			}
+		}
+		// only routes without policies are left
+		return nil
+	})
+}
+
+func (c *GlooClient) removeRoute(origin, destination string, matcher *v1.RequestMatcher, remaining func([]*v1.Route) []*v1.Route) error {
+	origin = originOrAll(origin)
+	vService, err := c.Store.V1().VirtualServices().Get(policy.Name(origin, destination))
 	if err != nil {
-		vService, err = c.Store.V1().VirtualServices().Create(&v1.VirtualService{
-			Name:               name,
-			Domains:            []string{"*"},
-			DisableForGateways: true,
-		})
-		if err != nil {
-			return err
+		return errors.Wrapf(err, "getting routes from %v to %v", origin, destination)
+	}
+	var routes []*v1.Route
+	for _, route := range vService.Routes {
+		if !route.GetRequestMatcher().Equal(matcher) {
+			routes = append(routes, route)
+		}
+	}
+	if len(routes) == len(vService.Routes) {
+		return errors.Errorf("no route from %v to %v matches %v", origin, destination, matcher)
+	}
+	routes = remaining(routes)
+	if len(routes) == 0 {
+		return c.DeletePolicy(origin, destination)
+	}
+	vService.Routes = routes
+	SortRoutes(vService.Routes)
+	_, err = c.Store.V1().VirtualServices().Update(vService)
+	return err
+}
+
+// DeletePolicy deletes the virtual service and the attribute from origin to destination
+func (c *GlooClient) DeletePolicy(origin, destination string) error {
+	origin = originOrAll(origin)
+	name := policy.Name(origin, destination)
+	var found bool
+	// the attribute goes first, so it never selects a missing virtual service
+	if _, err := c.Store.V1().Attributes().Get(name); err == nil {
+		found = true
+		if err := c.Store.V1().Attributes().Delete(name); err != nil {
+			return errors.Wrapf(err, "deleting attribute %v", name)
+		}
+	}
+	if _, err := c.Store.V1().VirtualServices().Get(name); err == nil {
+		found = true
+		if err := c.Store.V1().VirtualServices().Delete(name); err != nil {
+			return errors.Wrapf(err, "deleting virtual service %v", name)
+		}
+	}
+	if !found {
+		return errors.Errorf("there is no policy from %v to %v", origin, destination)
+	}
+	return nil
+}
+
+// mergeRoute replaces the route with the same matcher, or adds the route
+func mergeRoute(routes []*v1.Route, destination string, route Route) []*v1.Route {
+	newRoute := glooRoute(destination, route)
+	for i, existing := range routes {
+		if existing.GetRequestMatcher().Equal(route.Matcher) {
+			routes[i] = newRoute
+			return routes
 		}
 	}
-	// TODO: merge routes
-	vService.Routes = nil
-	vService.Routes = append(vService.Routes, &v1.Route{
+	return append(routes, newRoute)
+}
+
+// withCatchAll adds a route for all requests without any policy, unless there is one
+func withCatchAll(routes []*v1.Route, destination string) []*v1.Route {
+	for _, route := range routes {
+		if route.GetRequestMatcher().Equal(catchAll) {
+			return routes
+		}
+	}
+	return append(routes, glooRoute(destination, Route{Matcher: catchAll}))
+}
+
+func originOrAll(origin string) string {
+	if origin == "" {
+		return policy.AllOrigins
+	}
+	return origin
+}
+
+func glooRoute(destination string, route Route) *v1.Route {
+	return &v1.Route{
 		Extensions: route.Config,
 		Matcher:    &v1.Route_RequestMatcher{RequestMatcher: route.Matcher},
 		SingleDestination: &v1.Destination{
@@ -95,20 +216,46 @@ func (c *GlooClient) AddRoute(origin, destination string, route Route) error {
 				},
 			},
 		},
-	})
-	vService, err = c.Store.V1().VirtualServices().Update(vService)
+	}
+}
+
+// updateRoutes applies update to the routes from origin to destination, and
+// creates the virtual service and the attribute that selects its listeners
+func (c *GlooClient) updateRoutes(origin, destination string, update func([]*v1.Route) []*v1.Route) error {
+	origin = originOrAll(origin)
+	name := policy.Name(origin, destination)
+	vService, err := c.Store.V1().VirtualServices().Get(name)
 	if err != nil {
+		vService, err = c.Store.V1().VirtualServices().Create(&v1.VirtualService{
+			Name:               name,
+			Domains:            []string{"*"},
+			DisableForGateways: true,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	vService.Routes = update(vService.Routes)
+	SortRoutes(vService.Routes)
+	if _, err := c.Store.V1().VirtualServices().Update(vService); err != nil {
 		return err
 	}
-
-	// TODO(yuval-k): refactor these keys to a shared package with https://github.com/solo-io/gloo-connect/pull/13/files#diff-dd009a95782c9f59f4baeadcd504edd6R181
-	selector := map[string]string{
-		"destination": destination,
+	if err := c.writeAttribute(origin, destination); err != nil {
+		return err
 	}
-	if origin != allOrigins {
-		selector["service"] = origin
+	if origin != policy.AllOrigins {
+		// takes the all-origins policy of the destination off this origin's
+		// listeners, in case it was written before origins had precedence
+		if _, err := c.Store.V1().Attributes().Get(policy.Name(policy.AllOrigins, destination)); err == nil {
+			return c.writeAttribute(policy.AllOrigins, destination)
+		}
 	}
+	return nil
+}
 
+// writeAttribute selects the listeners the policy from origin to destination applies to
+func (c *GlooClient) writeAttribute(origin, destination string) error {
+	name := policy.Name(origin, destination)
 	attribute, err := c.Store.V1().Attributes().Get(name)
 	if err != nil {
 		attribute, err = c.Store.V1().Attributes().Create(&v1.Attribute{
@@ -123,7 +270,7 @@ func (c *GlooClient) AddRoute(origin, destination string, route Route) error {
 
 	attribute.AttributeType = &v1.Attribute_ListenerAttribute{
 		ListenerAttribute: &v1.ListenerAttribute{
-			Selector:        selector,
+			Selector:        policy.Selector(origin, destination),
 			VirtualServices: []string{name},
 		},
 	}
@@ -131,6 +278,42 @@ func (c *GlooClient) AddRoute(origin, destination string, route Route) error {
 	return err
 }
 
-func virtualServiceName(origin, destination string) string {
-	return fmt.Sprintf("%v-to-%v-routes", origin, destination)
+// SortRoutes sorts routes by how specific their matchers are, since envoy
+// takes the first route that matches: exact paths before regexes before
+// prefixes, longer prefixes first, and matchers with more conditions on
+// headers, query parameters and methods before the same path without them
+func SortRoutes(routes []*v1.Route) {
+	sort.SliceStable(routes, func(i, j int) bool {
+		return moreSpecific(routes[i].GetRequestMatcher(), routes[j].GetRequestMatcher())
+	})
+}
+
+func moreSpecific(a, b *v1.RequestMatcher) bool {
+	if pa, pb := pathRank(a), pathRank(b); pa != pb {
+		return pa < pb
+	}
+	if la, lb := len(a.GetPathPrefix()), len(b.GetPathPrefix()); la != lb {
+		return la > lb
+	}
+	return conditions(a) > conditions(b)
+}
+
+// pathRank orders the kinds of path matchers
+func pathRank(m *v1.RequestMatcher) int {
+	switch m.GetPath().(type) {
+	case *v1.RequestMatcher_PathExact:
+		return 0
+	case *v1.RequestMatcher_PathRegex:
+		return 1
+	case *v1.RequestMatcher_PathPrefix:
+		return 2
+	}
+	return 3
+}
+
+func conditions(m *v1.RequestMatcher) int {
+	if m == nil {
+		return 0
+	}
+	return len(m.Headers) + len(m.QueryParams) + len(m.Verbs)
 }
@@ -0,0 +1,276 @@
+package glooclient_test
+
+import (
+	"io/ioutil"
+	"os"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/gogo/protobuf/types"
+	. "github.com/solo-io/gloo-connect/pkg/cmd/glooclient"
+	"github.com/solo-io/gloo/pkg/api/types/v1"
+	"github.com/solo-io/gloo/pkg/storage/file"
+)
+
+func prefix(p string) *v1.RequestMatcher {
+	return &v1.RequestMatcher{Path: &v1.RequestMatcher_PathPrefix{PathPrefix: p}}
+}
+
+func exact(p string) *v1.RequestMatcher {
+	return &v1.RequestMatcher{Path: &v1.RequestMatcher_PathExact{PathExact: p}}
+}
+
+func regex(p string) *v1.RequestMatcher {
+	return &v1.RequestMatcher{Path: &v1.RequestMatcher_PathRegex{PathRegex: p}}
+}
+
+var _ = Describe("GlooClient", func() {
+	var (
+		dir string
+		gc  *GlooClient
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "glooclient")
+		Expect(err).NotTo(HaveOccurred())
+		store, err := file.NewStorage(dir, time.Second)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(store.V1().Register()).To(Succeed())
+		gc = &GlooClient{Store: store}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	matchers := func(origin, destination string) []*v1.RequestMatcher {
+		vs, err := gc.Store.V1().VirtualServices().Get(origin + "-to-" + destination + "-routes")
+		Expect(err).NotTo(HaveOccurred())
+		var matchers []*v1.RequestMatcher
+		for _, route := range vs.Routes {
+			matchers = append(matchers, route.GetRequestMatcher())
+		}
+		return matchers
+	}
+
+	It("merges routes and sorts them by specificity", func() {
+		for _, m := range []*v1.RequestMatcher{prefix("/"), prefix("/admin"), regex("/users/.*"), exact("/health")} {
+			Expect(gc.AddRoute("", "web", Route{Matcher: m})).To(Succeed())
+		}
+		Expect(matchers("all-origins", "web")).To(Equal([]*v1.RequestMatcher{
+			exact("/health"), regex("/users/.*"), prefix("/admin"), prefix("/"),
+		}))
+	})
+
+	It("replaces the route with the same matcher", func() {
+		Expect(gc.AddRoute("", "web", Route{Matcher: prefix("/")})).To(Succeed())
+		Expect(gc.AddRoute("", "web", Route{Matcher: prefix("/admin")})).To(Succeed())
+		Expect(gc.EnableBasicHttp("", "web", nil)).To(Succeed())
+		Expect(matchers("all-origins", "web")).To(Equal([]*v1.RequestMatcher{prefix("/admin"), prefix("/")}))
+	})
+
+	It("replaces and removes routes", func() {
+		Expect(gc.AddRoute("checkout", "web", Route{Matcher: prefix("/")})).To(Succeed())
+		Expect(gc.ReplaceRoutes("checkout", "web", []Route{{Matcher: prefix("/a")}, {Matcher: prefix("/b")}})).To(Succeed())
+		Expect(matchers("checkout", "web")).To(Equal([]*v1.RequestMatcher{prefix("/a"), prefix("/b")}))
+
+		Expect(gc.RemoveRoute("checkout", "web", prefix("/a"))).To(Succeed())
+		Expect(matchers("checkout", "web")).To(Equal([]*v1.RequestMatcher{prefix("/b")}))
+		Expect(gc.RemoveRoute("checkout", "web", prefix("/a"))).NotTo(Succeed())
+	})
+
+	It("deletes the policy when replacing its routes with none", func() {
+		Expect(gc.AddRoute("checkout", "web", Route{Matcher: prefix("/")})).To(Succeed())
+		Expect(gc.ReplaceRoutes("checkout", "web", nil)).To(Succeed())
+
+		_, err := gc.Store.V1().VirtualServices().Get("checkout-to-web-routes")
+		Expect(err).To(HaveOccurred())
+		_, err = gc.Store.V1().Attributes().Get("checkout-to-web-routes")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("keeps routing the requests that match no policy", func() {
+		gets := &v1.RequestMatcher{
+			Path:  &v1.RequestMatcher_PathPrefix{PathPrefix: "/"},
+			Verbs: []string{"GET"},
+		}
+		Expect(gc.ConfigureService("", "web", gets, 3, time.Second)).To(Succeed())
+		Expect(matchers("all-origins", "web")).To(Equal([]*v1.RequestMatcher{gets, prefix("/")}))
+
+		vs, err := gc.Store.V1().VirtualServices().Get("all-origins-to-web-routes")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(vs.Routes[0].Extensions).NotTo(BeNil())
+		Expect(vs.Routes[1].Extensions).To(BeNil())
+	})
+
+	It("selects the listeners of the origin for origin policies", func() {
+		Expect(gc.EnableBasicHttp("", "web", nil)).To(Succeed())
+		Expect(gc.EnableBasicHttp("checkout", "web", nil)).To(Succeed())
+
+		selector := func(name string) map[string]string {
+			attribute, err := gc.Store.V1().Attributes().Get(name)
+			Expect(err).NotTo(HaveOccurred())
+			return attribute.GetListenerAttribute().Selector
+		}
+		Expect(selector("all-origins-to-web-routes")).To(Equal(map[string]string{
+			"destination": "web",
+			"policy":      "all-origins",
+		}))
+		Expect(selector("checkout-to-web-routes")).To(Equal(map[string]string{
+			"service":     "checkout",
+			"destination": "web",
+			"policy":      "origin",
+		}))
+	})
+
+	It("flags policies whose virtual service is missing", func() {
+		Expect(gc.EnableBasicHttp("", "web", nil)).To(Succeed())
+		Expect(gc.EnableBasicHttp("", "db", nil)).To(Succeed())
+		Expect(gc.Store.V1().VirtualServices().Delete("all-origins-to-db-routes")).To(Succeed())
+
+		policies, err := gc.ListPolicies("")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(policies).To(HaveLen(2))
+		Expect(policies[0].Destination).To(Equal("db"))
+		Expect(policies[0].Missing).To(BeTrue())
+		Expect(policies[1].Missing).To(BeFalse())
+		Expect(policies[1].Routes).NotTo(BeEmpty())
+	})
+
+	It("reports routes that can't be read on their policy", func() {
+		Expect(gc.EnableBasicHttp("", "web", nil)).To(Succeed())
+		Expect(gc.ConfigureService("", "db", prefix("/admin"), 3, 0)).To(Succeed())
+		vs, err := gc.Store.V1().VirtualServices().Get("all-origins-to-db-routes")
+		Expect(err).NotTo(HaveOccurred())
+		vs.Routes[0].Extensions = &types.Struct{Fields: map[string]*types.Value{
+			"max_retries": {Kind: &types.Value_StringValue{StringValue: "lots"}},
+		}}
+		_, err = gc.Store.V1().VirtualServices().Update(vs)
+		Expect(err).NotTo(HaveOccurred())
+
+		policies, err := gc.ListPolicies("")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(policies).To(HaveLen(2))
+		Expect(policies[0].Destination).To(Equal("db"))
+		Expect(policies[0].Error).To(ContainSubstring("prefix:/admin"))
+		Expect(policies[0].Routes).To(HaveLen(1))
+		Expect(policies[1].Error).To(BeEmpty())
+	})
+
+	It("only lists attributes named like the policies set service writes", func() {
+		Expect(gc.EnableBasicHttp("", "web", nil)).To(Succeed())
+		_, err := gc.Store.V1().Attributes().Create(&v1.Attribute{
+			Name: "custom",
+			AttributeType: &v1.Attribute_ListenerAttribute{
+				ListenerAttribute: &v1.ListenerAttribute{
+					Selector:        map[string]string{"destination": "web"},
+					VirtualServices: []string{"all-origins-to-web-routes"},
+				},
+			},
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		policies, err := gc.ListPolicies("")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(policies).To(HaveLen(1))
+		Expect(policies[0].Name).To(Equal("all-origins-to-web-routes"))
+	})
+
+	It("lists the policies from or to a service", func() {
+		gets := &v1.RequestMatcher{
+			Path:  &v1.RequestMatcher_PathPrefix{PathPrefix: "/"},
+			Verbs: []string{"GET"},
+		}
+		Expect(gc.ConfigureService("checkout", "web", gets, 3, time.Second)).To(Succeed())
+		Expect(gc.EnableBasicHttp("", "db", nil)).To(Succeed())
+
+		policies, err := gc.ListPolicies("checkout")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(policies).To(HaveLen(1))
+		Expect(policies[0].Origin).To(Equal("checkout"))
+		Expect(policies[0].Destination).To(Equal("web"))
+		Expect(policies[0].Routes).To(HaveLen(2))
+		route := policies[0].Routes[0]
+		Expect(route.Matcher).To(Equal(Matcher{PathPrefix: "/", Methods: []string{"GET"}}))
+		Expect(route.Retries).To(Equal(uint32(3)))
+		Expect(route.Timeout).To(Equal("1s"))
+		Expect(route.OtherExtensions()).To(BeEmpty())
+
+		policies, err = gc.ListPolicies("")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(policies).To(HaveLen(2))
+	})
+
+	Context("unsetting a service", func() {
+		gets := &v1.RequestMatcher{
+			Path:  &v1.RequestMatcher_PathPrefix{PathPrefix: "/"},
+			Verbs: []string{"GET"},
+		}
+
+		exists := func(name string) bool {
+			_, vsErr := gc.Store.V1().VirtualServices().Get(name)
+			_, attrErr := gc.Store.V1().Attributes().Get(name)
+			Expect(vsErr == nil).To(Equal(attrErr == nil))
+			return vsErr == nil
+		}
+
+		BeforeEach(func() {
+			Expect(gc.ConfigureService("checkout", "web", gets, 3, 0)).To(Succeed())
+			Expect(gc.ConfigureService("checkout", "web", prefix("/admin"), 0, time.Second)).To(Succeed())
+		})
+
+		It("removes the route with the matcher, and keeps the rest routed", func() {
+			Expect(gc.UnsetService("checkout", "web", prefix("/admin"))).To(Succeed())
+			Expect(matchers("checkout", "web")).To(Equal([]*v1.RequestMatcher{gets, prefix("/")}))
+		})
+
+		It("deletes the policy once no policy routes are left", func() {
+			Expect(gc.UnsetService("checkout", "web", prefix("/admin"))).To(Succeed())
+			Expect(gc.UnsetService("checkout", "web", gets)).To(Succeed())
+			Expect(exists("checkout-to-web-routes")).To(BeFalse())
+		})
+
+		It("deletes the whole policy without a matcher", func() {
+			Expect(gc.UnsetService("checkout", "web", nil)).To(Succeed())
+			Expect(exists("checkout-to-web-routes")).To(BeFalse())
+			Expect(gc.UnsetService("checkout", "web", nil)).NotTo(Succeed())
+		})
+	})
+
+	It("rewrites the selectors of legacy policies", func() {
+		legacy := map[string]map[string]string{
+			"all-origins-to-db-routes": {"destination": "db"},
+			"web-to-db-routes":         {"destination": "db", "service": "web"},
+			// not written by the cli
+			"custom": {"destination": "db"},
+		}
+		for name, selector := range legacy {
+			_, err := gc.Store.V1().Attributes().Create(&v1.Attribute{
+				Name: name,
+				AttributeType: &v1.Attribute_ListenerAttribute{
+					ListenerAttribute: &v1.ListenerAttribute{Selector: selector},
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		migrated, err := gc.MigrateLegacyPolicies()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(migrated).To(ConsistOf("all-origins-to-db-routes", "web-to-db-routes"))
+		selector := func(name string) map[string]string {
+			attribute, err := gc.Store.V1().Attributes().Get(name)
+			Expect(err).NotTo(HaveOccurred())
+			return attribute.GetListenerAttribute().Selector
+		}
+		Expect(selector("all-origins-to-db-routes")).To(Equal(map[string]string{"destination": "db", "policy": "all-origins"}))
+		Expect(selector("web-to-db-routes")).To(Equal(map[string]string{"destination": "db", "service": "web", "policy": "origin"}))
+		Expect(selector("custom")).To(Equal(map[string]string{"destination": "db"}))
+
+		migrated, err = gc.MigrateLegacyPolicies()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(migrated).To(BeEmpty())
+	})
+})
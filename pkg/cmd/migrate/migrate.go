@@ -0,0 +1,32 @@
+package migrate
+
+import (
+	"fmt"
+
+	"github.com/solo-io/gloo-connect/pkg/cmd/glooclient"
+	"github.com/solo-io/gloo-connect/pkg/runner"
+	"github.com/solo-io/gloo/pkg/bootstrap/configstorage"
+	"github.com/spf13/cobra"
+)
+
+func Cmd(rc *runner.RunConfig) *cobra.Command {
+	return &cobra.Command{
+		Use:   "migrate",
+		Short: "rewrite the selectors of policies set by older versions; run once every bridge is upgraded",
+		Args:  cobra.NoArgs,
+		RunE: func(c *cobra.Command, args []string) error {
+			store, err := configstorage.Bootstrap(rc.Options)
+			if err != nil {
+				return err
+			}
+
+			gc := glooclient.GlooClient{Store: store}
+
+			migrated, err := gc.MigrateLegacyPolicies()
+			for _, name := range migrated {
+				fmt.Printf("migrated policy %v\n", name)
+			}
+			return err
+		},
+	}
+}
@@ -1,9 +1,16 @@
 package cmd
 
 import (
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
 	"github.com/solo-io/gloo-connect/pkg/cmd/bridge"
+	"github.com/solo-io/gloo-connect/pkg/cmd/debug"
 	"github.com/solo-io/gloo-connect/pkg/cmd/get"
+	"github.com/solo-io/gloo-connect/pkg/cmd/migrate"
 	"github.com/solo-io/gloo-connect/pkg/cmd/set"
+	"github.com/solo-io/gloo-connect/pkg/cmd/unset"
 	"github.com/solo-io/gloo-connect/pkg/runner"
 	"github.com/solo-io/gloo/pkg/bootstrap"
 	"github.com/solo-io/gloo/pkg/bootstrap/flags"
@@ -11,21 +18,61 @@ import (
 	"github.com/spf13/cobra"
 )
 
+const defaultRefreshRate = time.Second
+
+// storage backends for gloo config objects and files
+var storageTypes = []string{
+	bootstrap.WatcherTypeConsul,
+	bootstrap.WatcherTypeFile,
+	bootstrap.WatcherTypeKube,
+}
+
 func Cmd(rc *runner.RunConfig) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "gloo-connect",
 		Short: "root command for running and managing gloo-connect",
+		PersistentPreRunE: func(_ *cobra.Command, _ []string) error {
+			return validateStorage(rc.Options)
+		},
 	}
 	// for storage and service discovery
 	flags.AddConsulFlags(cmd, &rc.Options)
+	// for the file and kubernetes storage backends
+	flags.AddFileFlags(cmd, &rc.Options)
+	flags.AddKubernetesFlags(cmd, &rc.Options)
+
+	supported := strings.Join(storageTypes, " | ")
+	cmd.PersistentFlags().StringVar(&rc.Options.ConfigStorageOptions.Type, "storage.type", bootstrap.WatcherTypeConsul, "storage backend for gloo config objects, such as virtual services. supported: ["+supported+"]")
+	cmd.PersistentFlags().StringVar(&rc.Options.FileStorageOptions.Type, "filestorage.type", bootstrap.WatcherTypeConsul, "storage backend for gloo files. supported: ["+supported+"]")
+	// the file backend polls; it never sees an edit with a zero rate
+	cmd.PersistentFlags().DurationVar(&rc.Options.ConfigStorageOptions.SyncFrequency, "storage.refreshrate", defaultRefreshRate, "how often the storage backend for gloo config objects is resynced, e.g. to pick up edited files")
+	cmd.PersistentFlags().DurationVar(&rc.Options.FileStorageOptions.SyncFrequency, "filestorage.refreshrate", defaultRefreshRate, "how often the storage backend for gloo files is resynced")
 
-	initRunnerConfig(rc)
-	cmd.AddCommand(bridge.Cmd(rc), get.Cmd(rc), set.Cmd(rc), completionCmd())
+	cmd.AddCommand(bridge.Cmd(rc), get.Cmd(rc), set.Cmd(rc), unset.Cmd(rc), debug.Cmd(), migrate.Cmd(rc), completionCmd())
 	return cmd
 }
 
-func initRunnerConfig(c *runner.RunConfig) {
-	// always use consul for storage and service discovery
-	c.Options.ConfigStorageOptions.Type = bootstrap.WatcherTypeConsul
-	c.Options.FileStorageOptions.Type = bootstrap.WatcherTypeConsul
+func validateStorage(opts bootstrap.Options) error {
+	if !isStorageType(opts.ConfigStorageOptions.Type) {
+		return errors.Errorf("unsupported storage.type %q, must be one of [%v]", opts.ConfigStorageOptions.Type, strings.Join(storageTypes, " | "))
+	}
+	if !isStorageType(opts.FileStorageOptions.Type) {
+		return errors.Errorf("unsupported filestorage.type %q, must be one of [%v]", opts.FileStorageOptions.Type, strings.Join(storageTypes, " | "))
+	}
+	if opts.ConfigStorageOptions.SyncFrequency <= 0 {
+		return errors.Errorf("storage.refreshrate must be positive, got %v", opts.ConfigStorageOptions.SyncFrequency)
+	}
+	if opts.FileStorageOptions.SyncFrequency <= 0 {
+		return errors.Errorf("filestorage.refreshrate must be positive, got %v", opts.FileStorageOptions.SyncFrequency)
+	}
+	return nil
+}
+
+func isStorageType(typ string) bool {
+	for _, t := range storageTypes {
+		if t == typ {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,73 @@
+package cmd_test
+
+import (
+	"io/ioutil"
+	"os"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/solo-io/gloo-connect/pkg/cmd"
+	"github.com/solo-io/gloo-connect/pkg/runner"
+	"github.com/solo-io/gloo/pkg/api/types/v1"
+	"github.com/solo-io/gloo/pkg/bootstrap/configstorage"
+	"github.com/solo-io/gloo/pkg/storage/file"
+)
+
+// virtualServiceNames sends the names of the virtual services after every event
+type virtualServiceNames chan string
+
+func (n virtualServiceNames) send(list []*v1.VirtualService) {
+	for _, vs := range list {
+		n <- vs.Name
+	}
+}
+
+func (n virtualServiceNames) OnAdd(list []*v1.VirtualService, _ *v1.VirtualService)    { n.send(list) }
+func (n virtualServiceNames) OnUpdate(list []*v1.VirtualService, _ *v1.VirtualService) { n.send(list) }
+func (n virtualServiceNames) OnDelete(list []*v1.VirtualService, _ *v1.VirtualService) { n.send(list) }
+
+var _ = Describe("Cmd", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "cmd")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("picks up edits to file storage with the default flags", func() {
+		rc := &runner.RunConfig{}
+		cmd := Cmd(rc)
+		Expect(cmd.PersistentFlags().Parse([]string{"--storage.type=file", "--file.config.dir=" + dir})).To(Succeed())
+
+		store, err := configstorage.Bootstrap(rc.Options)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(store.V1().Register()).To(Succeed())
+		names := make(virtualServiceNames, 10)
+		watcher, err := store.V1().VirtualServices().Watch(names)
+		Expect(err).NotTo(HaveOccurred())
+		stop := make(chan struct{})
+		defer close(stop)
+		go watcher.Run(stop, make(chan error, 10))
+
+		// e.g. a git pull
+		other, err := file.NewStorage(dir, time.Second)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = other.V1().VirtualServices().Create(&v1.VirtualService{Name: "web-to-db-routes"})
+		Expect(err).NotTo(HaveOccurred())
+		Eventually(names, "5s").Should(Receive(Equal("web-to-db-routes")))
+	})
+
+	It("rejects a refresh rate that isn't positive", func() {
+		rc := &runner.RunConfig{}
+		cmd := Cmd(rc)
+		Expect(cmd.PersistentFlags().Parse([]string{"--storage.refreshrate=0s"})).To(Succeed())
+		Expect(cmd.PersistentPreRunE(cmd, nil)).To(MatchError(ContainSubstring("storage.refreshrate")))
+	})
+})
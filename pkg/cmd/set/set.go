@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/solo-io/gloo-connect/pkg/cmd/glooclient"
+	"github.com/solo-io/gloo-connect/pkg/cmd/util"
 	"github.com/solo-io/gloo-connect/pkg/runner"
 	"github.com/solo-io/gloo/pkg/bootstrap/configstorage"
 	"github.com/spf13/cobra"
@@ -15,6 +16,8 @@ type serviceFlagsType struct {
 	retries uint32
 	http    bool
 	timeout time.Duration
+	matcher util.MatcherFlags
+	from    string
 }
 
 var serviceFlags = serviceFlagsType{}
@@ -37,6 +40,10 @@ func cmdSetServices(rc *runner.RunConfig) *cobra.Command {
 			if err != nil {
 				return err
 			}
+			matcher, err := serviceFlags.matcher.Matcher()
+			if err != nil {
+				return err
+			}
 			store, err := configstorage.Bootstrap(rc.Options)
 			if err != nil {
 				return err
@@ -44,12 +51,14 @@ func cmdSetServices(rc *runner.RunConfig) *cobra.Command {
 
 			gc := glooclient.GlooClient{Store: store}
 
-			return gc.ConfigureService(args[0], serviceFlags.retries, serviceFlags.timeout)
+			return gc.ConfigureService(serviceFlags.from, args[0], matcher, serviceFlags.retries, serviceFlags.timeout)
 		},
 	}
 	cmd.PersistentFlags().Uint32VarP(&serviceFlags.retries, "retries", "", 0, "max number of http connection retries. Value of \"0\" specifies continuous connection retries. Default 0")
 	cmd.PersistentFlags().BoolVarP(&serviceFlags.http, "http", "", false, "whether http mode should be used, default false")
 	cmd.PersistentFlags().DurationVarP(&serviceFlags.timeout, "timeout", "", 0, "connection timeout duration (2m, 1h, 20s, etc.). Value of \"0\" indicates no timeout. Default 0")
+	cmd.PersistentFlags().StringVar(&serviceFlags.from, "from", "", "only apply the policy to requests from this service; it then takes precedence over the policy for all services")
+	util.AddMatcherFlags(cmd.PersistentFlags(), &serviceFlags.matcher)
 	return cmd
 }
 
@@ -0,0 +1,56 @@
+package unset
+
+import (
+	"github.com/solo-io/gloo-connect/pkg/cmd/glooclient"
+	"github.com/solo-io/gloo-connect/pkg/cmd/util"
+	"github.com/solo-io/gloo-connect/pkg/runner"
+	"github.com/solo-io/gloo/pkg/api/types/v1"
+	"github.com/solo-io/gloo/pkg/bootstrap/configstorage"
+	"github.com/spf13/cobra"
+)
+
+type serviceFlagsType struct {
+	from    string
+	matcher util.MatcherFlags
+}
+
+var serviceFlags = serviceFlagsType{}
+
+func Cmd(rc *runner.RunConfig) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "unset",
+		Aliases: []string{"delete"},
+		Short:   "remove policies set with set",
+	}
+	cmd.AddCommand(cmdUnsetService(rc))
+	return cmd
+}
+
+func cmdUnsetService(rc *runner.RunConfig) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "service [service_name]",
+		Short: "remove the policy for a service, or only its route for the requests matching the matcher flags",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(c *cobra.Command, args []string) error {
+			var matcher *v1.RequestMatcher
+			if serviceFlags.matcher.IsSet() {
+				var err error
+				matcher, err = serviceFlags.matcher.Matcher()
+				if err != nil {
+					return err
+				}
+			}
+			store, err := configstorage.Bootstrap(rc.Options)
+			if err != nil {
+				return err
+			}
+
+			gc := glooclient.GlooClient{Store: store}
+
+			return gc.UnsetService(serviceFlags.from, args[0], matcher)
+		},
+	}
+	cmd.PersistentFlags().StringVar(&serviceFlags.from, "from", "", "remove the policy for requests from this service, instead of the one for all services")
+	util.AddMatcherFlags(cmd.PersistentFlags(), &serviceFlags.matcher)
+	return cmd
+}
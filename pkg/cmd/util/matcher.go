@@ -0,0 +1,93 @@
+package util
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/solo-io/gloo/pkg/api/types/v1"
+	"github.com/spf13/pflag"
+)
+
+// MatcherFlags select the requests a policy applies to
+type MatcherFlags struct {
+	PathExact  string
+	PathPrefix string
+	PathRegex  string
+	Headers    []string
+	Query      []string
+	Methods    []string
+}
+
+func AddMatcherFlags(flags *pflag.FlagSet, m *MatcherFlags) {
+	flags.StringVar(&m.PathExact, "path-exact", "", "match requests with exactly this path")
+	flags.StringVar(&m.PathPrefix, "path-prefix", "", "match requests whose path starts with this prefix; \"/\" if no path matcher is set")
+	flags.StringVar(&m.PathRegex, "path-regex", "", "match requests whose path matches this regular expression")
+	flags.StringSliceVar(&m.Headers, "header", nil, "match requests with this header, as name=value; can be repeated")
+	flags.StringSliceVar(&m.Query, "query", nil, "match requests with this query parameter, as name=value; can be repeated")
+	flags.StringSliceVar(&m.Methods, "method", nil, "match requests with this http method, e.g. GET; can be repeated")
+}
+
+// IsSet returns true if any of the matcher flags was set
+func (m MatcherFlags) IsSet() bool {
+	return m.PathExact != "" || m.PathPrefix != "" || m.PathRegex != "" ||
+		len(m.Headers) > 0 || len(m.Query) > 0 || len(m.Methods) > 0
+}
+
+// Matcher returns the request matcher of the flags
+func (m MatcherFlags) Matcher() (*v1.RequestMatcher, error) {
+	matcher := &v1.RequestMatcher{}
+	var paths int
+	if m.PathExact != "" {
+		paths++
+		matcher.Path = &v1.RequestMatcher_PathExact{PathExact: m.PathExact}
+	}
+	if m.PathPrefix != "" {
+		paths++
+		matcher.Path = &v1.RequestMatcher_PathPrefix{PathPrefix: m.PathPrefix}
+	}
+	if m.PathRegex != "" {
+		paths++
+		matcher.Path = &v1.RequestMatcher_PathRegex{PathRegex: m.PathRegex}
+	}
+	switch paths {
+	case 0:
+		matcher.Path = &v1.RequestMatcher_PathPrefix{PathPrefix: "/"}
+	case 1:
+	default:
+		return nil, errors.New("only one of --path-exact, --path-prefix and --path-regex can be set")
+	}
+	var err error
+	if matcher.Headers, err = parseKeyValues("header", m.Headers); err != nil {
+		return nil, err
+	}
+	if matcher.QueryParams, err = parseKeyValues("query", m.Query); err != nil {
+		return nil, err
+	}
+	// sorted and deduplicated, so the same flags always give the same matcher
+	verbs := make(map[string]bool)
+	for _, method := range m.Methods {
+		verb := strings.ToUpper(method)
+		if !verbs[verb] {
+			verbs[verb] = true
+			matcher.Verbs = append(matcher.Verbs, verb)
+		}
+	}
+	sort.Strings(matcher.Verbs)
+	return matcher, nil
+}
+
+func parseKeyValues(flag string, pairs []string) (map[string]string, error) {
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+	values := make(map[string]string)
+	for _, pair := range pairs {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, errors.Errorf("--%v %q must be name=value", flag, pair)
+		}
+		values[kv[0]] = kv[1]
+	}
+	return values, nil
+}
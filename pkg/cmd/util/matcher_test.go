@@ -0,0 +1,35 @@
+package util_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/solo-io/gloo-connect/pkg/cmd/util"
+	"github.com/solo-io/gloo/pkg/api/types/v1"
+)
+
+var _ = Describe("MatcherFlags", func() {
+	It("matches every path by default", func() {
+		matcher, err := MatcherFlags{}.Matcher()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(matcher.Path).To(Equal(&v1.RequestMatcher_PathPrefix{PathPrefix: "/"}))
+	})
+
+	It("sorts and deduplicates the methods", func() {
+		matcher, err := MatcherFlags{Methods: []string{"post", "GET", "get"}}.Matcher()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(matcher.Verbs).To(Equal([]string{"GET", "POST"}))
+	})
+
+	It("rejects more than one path matcher", func() {
+		_, err := MatcherFlags{PathExact: "/health", PathPrefix: "/"}.Matcher()
+		Expect(err).To(MatchError(ContainSubstring("only one of")))
+	})
+
+	It("rejects headers and query parameters that aren't name=value", func() {
+		_, err := MatcherFlags{Headers: []string{"x-user"}}.Matcher()
+		Expect(err).To(MatchError(`--header "x-user" must be name=value`))
+		_, err = MatcherFlags{Query: []string{"=1"}}.Matcher()
+		Expect(err).To(MatchError(`--query "=1" must be name=value`))
+	})
+})
@@ -0,0 +1,155 @@
+package coalesce
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/solo-io/gloo-connect/pkg/backoff"
+	"github.com/solo-io/gloo-connect/pkg/consul"
+	"github.com/solo-io/gloo-connect/pkg/types"
+	"github.com/solo-io/gloo/pkg/log"
+)
+
+// Snapshot is a consistent view of everything the bridge pushes to gloo
+type Snapshot struct {
+	Roots  types.Certificates
+	Leaf   types.CertificateAndKey
+	Config *api.ConnectProxyConfig
+}
+
+func (s Snapshot) complete() bool {
+	return len(s.Roots) > 0 && s.Leaf.Certificate != "" && s.Config != nil
+}
+
+// Update is a snapshot, and what changed since the last one that was applied
+type Update struct {
+	Snapshot
+	CertsChanged  bool
+	ConfigChanged bool
+}
+
+// Coalescer batches changes to the roots, the leaf and the proxy config, and
+// applies them together once no change came in for the quiet period, or at
+// the latest max delay after the first change. Nothing is applied until the
+// snapshot is complete. Failed updates are retried with backoff.
+type Coalescer struct {
+	quietPeriod time.Duration
+	maxDelay    time.Duration
+	apply       func(Update) error
+
+	lock    sync.Mutex
+	pending Snapshot
+	applied Snapshot
+	changed chan struct{}
+	ready   chan struct{}
+}
+
+var _ consul.ConfigWriter = &Coalescer{}
+
+func New(quietPeriod, maxDelay time.Duration, apply func(Update) error) *Coalescer {
+	return &Coalescer{
+		quietPeriod: quietPeriod,
+		maxDelay:    maxDelay,
+		apply:       apply,
+		changed:     make(chan struct{}, 1),
+		ready:       make(chan struct{}),
+	}
+}
+
+func (c *Coalescer) SetRoots(roots types.Certificates) {
+	c.update(func(s *Snapshot) { s.Roots = roots })
+}
+
+func (c *Coalescer) SetLeaf(leaf types.CertificateAndKey) {
+	c.update(func(s *Snapshot) { s.Leaf = leaf })
+}
+
+// Write queues the proxy config; errors applying it are retried by the coalescer
+func (c *Coalescer) Write(cfg *api.ConnectProxyConfig) error {
+	c.update(func(s *Snapshot) { s.Config = cfg })
+	return nil
+}
+
+// Config returns the latest proxy config, whether it was applied yet or not
+func (c *Coalescer) Config() *api.ConnectProxyConfig {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.pending.Config
+}
+
+func (c *Coalescer) update(fn func(*Snapshot)) {
+	c.lock.Lock()
+	fn(&c.pending)
+	c.lock.Unlock()
+	select {
+	case c.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Ready is closed once the first complete snapshot was applied
+func (c *Coalescer) Ready() <-chan struct{} {
+	return c.ready
+}
+
+// Run applies changes until ctx is cancelled
+func (c *Coalescer) Run(ctx context.Context) {
+	var (
+		quiet, deadline, retry <-chan time.Time
+		retryBackoff           backoff.Backoff
+	)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.changed:
+			quiet = time.After(c.quietPeriod)
+			if deadline == nil {
+				deadline = time.After(c.maxDelay)
+			}
+			continue
+		case <-quiet:
+		case <-deadline:
+		case <-retry:
+		}
+		quiet, deadline, retry = nil, nil, nil
+		if err := c.applyPending(); err != nil {
+			delay := retryBackoff.Next()
+			log.Warnf("failed to apply update, retrying in %v: %v", delay, err)
+			retry = time.After(delay)
+			continue
+		}
+		retryBackoff.Reset()
+	}
+}
+
+func (c *Coalescer) applyPending() error {
+	c.lock.Lock()
+	snapshot, applied := c.pending, c.applied
+	c.lock.Unlock()
+	if !snapshot.complete() {
+		return nil
+	}
+	update := Update{
+		Snapshot:      snapshot,
+		CertsChanged:  !reflect.DeepEqual(snapshot.Roots, applied.Roots) || snapshot.Leaf != applied.Leaf,
+		ConfigChanged: snapshot.Config != applied.Config,
+	}
+	if !update.CertsChanged && !update.ConfigChanged {
+		return nil
+	}
+	if err := c.apply(update); err != nil {
+		return err
+	}
+	c.lock.Lock()
+	first := !c.applied.complete()
+	c.applied = snapshot
+	c.lock.Unlock()
+	if first {
+		close(c.ready)
+	}
+	return nil
+}
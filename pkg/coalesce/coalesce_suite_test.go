@@ -0,0 +1,13 @@
+package coalesce_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestCoalesce(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Coalesce Suite")
+}
@@ -0,0 +1,106 @@
+package coalesce_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/solo-io/gloo-connect/pkg/coalesce"
+	"github.com/solo-io/gloo-connect/pkg/types"
+)
+
+var _ = Describe("Coalescer", func() {
+	var (
+		ctx     context.Context
+		cancel  context.CancelFunc
+		updates chan Update
+		fail    chan error
+		c       *Coalescer
+	)
+
+	roots := types.Certificates{"root"}
+	leaf := types.CertificateAndKey{Certificate: "leaf", PrivateKey: "key"}
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithCancel(context.Background())
+		updates = make(chan Update, 10)
+		fail = make(chan error, 1)
+		c = New(50*time.Millisecond, 200*time.Millisecond, func(update Update) error {
+			select {
+			case err := <-fail:
+				return err
+			default:
+			}
+			updates <- update
+			return nil
+		})
+		go c.Run(ctx)
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	It("applies nothing until the snapshot is complete", func() {
+		c.SetRoots(roots)
+		c.SetLeaf(leaf)
+		Consistently(updates, "100ms").ShouldNot(Receive())
+		Expect(c.Ready()).NotTo(BeClosed())
+
+		c.Write(&api.ConnectProxyConfig{ProxyServiceID: "web-proxy"})
+		var update Update
+		Eventually(updates).Should(Receive(&update))
+		Expect(update.CertsChanged).To(BeTrue())
+		Expect(update.ConfigChanged).To(BeTrue())
+		Expect(c.Ready()).To(BeClosed())
+	})
+
+	It("batches changes into one update", func() {
+		c.SetRoots(roots)
+		c.SetLeaf(leaf)
+		c.Write(&api.ConnectProxyConfig{ProxyServiceID: "web-proxy"})
+		Eventually(updates).Should(Receive())
+
+		cfg := &api.ConnectProxyConfig{ProxyServiceID: "web-proxy", ContentHash: "2"}
+		c.SetRoots(types.Certificates{"root", "new-root"})
+		c.Write(cfg)
+		var update Update
+		Eventually(updates).Should(Receive(&update))
+		Expect(update.CertsChanged).To(BeTrue())
+		Expect(update.Config).To(Equal(cfg))
+		Consistently(updates, "100ms").ShouldNot(Receive())
+	})
+
+	It("applies at the latest after the max delay", func() {
+		c.SetRoots(roots)
+		c.SetLeaf(leaf)
+		start := time.Now()
+		// keep changing the config faster than the quiet period
+		for time.Since(start) < 300*time.Millisecond {
+			c.Write(&api.ConnectProxyConfig{ProxyServiceID: "web-proxy"})
+			time.Sleep(10 * time.Millisecond)
+		}
+		Expect(updates).To(Receive())
+	})
+
+	It("retries failed updates with the latest snapshot", func() {
+		fail <- errors.New("sync failed")
+		c.SetRoots(roots)
+		c.SetLeaf(leaf)
+		c.Write(&api.ConnectProxyConfig{ProxyServiceID: "web-proxy"})
+		Eventually(fail).Should(BeEmpty())
+
+		// the config changes while the update waits to be retried
+		cfg := &api.ConnectProxyConfig{ProxyServiceID: "web-proxy", ContentHash: "2"}
+		c.Write(cfg)
+		var update Update
+		Eventually(updates, "2s").Should(Receive(&update))
+		Expect(update.Config).To(Equal(cfg))
+		Consistently(updates, "100ms").ShouldNot(Receive())
+	})
+})
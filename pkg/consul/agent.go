@@ -0,0 +1,127 @@
+package consul
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/pkg/errors"
+)
+
+// StatusError is returned when the agent answered with an error status
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("agent returned %v: %v", e.StatusCode, strings.TrimSpace(e.Body))
+}
+
+// IsNotFound returns true if the agent answered with a 404
+func IsNotFound(err error) bool {
+	statusErr, ok := errors.Cause(err).(*StatusError)
+	return ok && statusErr.StatusCode == http.StatusNotFound
+}
+
+// NewAgentHTTPClient returns a plain http client for the agent in cfg, and
+// the address to send its requests to
+func NewAgentHTTPClient(cfg *api.Config) (*http.Client, string, error) {
+	client, err := api.NewHttpClient(api.DefaultConfig().Transport, cfg.TLSConfig)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "creating agent http client")
+	}
+	scheme := cfg.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+	address := cfg.Address
+	if strings.HasPrefix(address, "unix://") {
+		path := strings.TrimPrefix(address, "unix://")
+		client.Transport.(*http.Transport).DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		}
+		scheme, address = "http", "consul"
+	}
+	return client, scheme + "://" + address, nil
+}
+
+// ProxyConfigAgent gets the proxy config with plain http requests, so a
+// deregistered proxy shows up as a StatusError. The rest of ConnectClient
+// comes from the api client's agent.
+type ProxyConfigAgent struct {
+	*api.Agent
+	client  *http.Client
+	address string
+	token   string
+}
+
+var _ ConnectClient = &ProxyConfigAgent{}
+
+func NewProxyConfigAgent(cfg *api.Config, agent *api.Agent) (*ProxyConfigAgent, error) {
+	client, address, err := NewAgentHTTPClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &ProxyConfigAgent{
+		Agent:   agent,
+		client:  client,
+		address: address,
+		token:   cfg.Token,
+	}, nil
+}
+
+func (a *ProxyConfigAgent) ConnectProxyConfig(proxyid string, q *api.QueryOptions) (*api.ConnectProxyConfig, *api.QueryMeta, error) {
+	params := url.Values{}
+	token := a.token
+	if q != nil {
+		if q.WaitIndex != 0 {
+			params.Set("index", strconv.FormatUint(q.WaitIndex, 10))
+		}
+		if q.WaitTime != 0 {
+			params.Set("wait", q.WaitTime.String())
+		}
+		if q.Token != "" {
+			token = q.Token
+		}
+	}
+	req, err := http.NewRequest(http.MethodGet, a.address+"/v1/agent/connect/proxy/"+url.PathEscape(proxyid)+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if q != nil && q.Context() != nil {
+		req = req.WithContext(q.Context())
+	}
+	if token != "" {
+		req.Header.Set("X-Consul-Token", token)
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return nil, nil, &StatusError{StatusCode: resp.StatusCode, Body: string(msg)}
+	}
+	var out api.ConnectProxyConfig
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, nil, errors.Wrap(err, "decoding proxy config")
+	}
+	meta := &api.QueryMeta{}
+	// agents that don't block on the proxy config send no index
+	if index := resp.Header.Get("X-Consul-Index"); index != "" {
+		meta.LastIndex, err = strconv.ParseUint(index, 10, 64)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "invalid index %q", index)
+		}
+	}
+	return &out, meta, nil
+}
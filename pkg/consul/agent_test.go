@@ -0,0 +1,57 @@
+package consul_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/solo-io/gloo-connect/pkg/consul"
+)
+
+var _ = Describe("ProxyConfigAgent", func() {
+	var (
+		server *httptest.Server
+		agent  *ProxyConfigAgent
+	)
+
+	BeforeEach(func() {
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/v1/agent/connect/proxy/web-proxy" {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte("unknown proxy service ID: " + strings.TrimPrefix(r.URL.Path, "/v1/agent/connect/proxy/")))
+				return
+			}
+			if r.URL.Query().Get("index") != "7" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("X-Consul-Index", "8")
+			w.Write([]byte(`{"ProxyServiceID": "web-proxy", "TargetServiceName": "web"}`))
+		}))
+		var err error
+		agent, err = NewProxyConfigAgent(&api.Config{Address: strings.TrimPrefix(server.URL, "http://")}, nil)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("blocks on the index of the last proxy config", func() {
+		pcfg, meta, err := agent.ConnectProxyConfig("web-proxy", &api.QueryOptions{WaitIndex: 7})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pcfg.TargetServiceName).To(Equal("web"))
+		Expect(meta.LastIndex).To(Equal(uint64(8)))
+	})
+
+	It("reports a deregistered proxy as not found", func() {
+		_, _, err := agent.ConnectProxyConfig("db-proxy", nil)
+		Expect(IsNotFound(err)).To(BeTrue())
+		Expect(err.Error()).To(ContainSubstring("unknown proxy service ID: db-proxy"))
+	})
+})
@@ -7,6 +7,7 @@ import (
 
 	"github.com/hashicorp/consul/api"
 	"github.com/solo-io/gloo-connect/pkg/types"
+	"github.com/solo-io/gloo/pkg/log"
 )
 
 type ConfigWriter interface {
@@ -22,14 +23,21 @@ type ConnectClient interface {
 type CertificateFetcher interface {
 	Certs() <-chan types.CertificateAndKey
 	RootCerts() <-chan types.Certificates
+	// closed when the proxy is deregistered from the agent
+	ProxyDeregistered() <-chan struct{}
 }
 
+// agents that don't support blocking queries on the proxy config are polled
+const proxyConfigPollInterval = 10 * time.Second
+
 type certificateFetcher struct {
 	c ConnectClient
 
 	certs     chan types.CertificateAndKey
 	rootCerts chan types.Certificates
 
+	proxyDeregistered chan struct{}
+
 	configWriter ConfigWriter
 }
 
@@ -41,6 +49,10 @@ func (c *certificateFetcher) RootCerts() <-chan types.Certificates {
 	return c.rootCerts
 }
 
+func (c *certificateFetcher) ProxyDeregistered() <-chan struct{} {
+	return c.proxyDeregistered
+}
+
 func NewCertificateFetcher(ctx context.Context, configWriter ConfigWriter, cfg ConsulConnectConfig) (CertificateFetcher, error) {
 	consulConfig := api.DefaultConfig()
 	consulConfig.Token = cfg.Token()
@@ -49,7 +61,11 @@ func NewCertificateFetcher(ctx context.Context, configWriter ConfigWriter, cfg C
 	if err != nil {
 		return nil, err
 	}
-	return NewCertificateFetcherFromInterface(ctx, configWriter, cfg, client.Agent())
+	agent, err := NewProxyConfigAgent(consulConfig, client.Agent())
+	if err != nil {
+		return nil, err
+	}
+	return NewCertificateFetcherFromInterface(ctx, configWriter, cfg, agent)
 }
 
 func NewCertificateFetcherFromInterface(ctx context.Context, configWriter ConfigWriter, cfg ConsulConnectConfig, client ConnectClient) (CertificateFetcher, error) {
@@ -58,6 +74,8 @@ func NewCertificateFetcherFromInterface(ctx context.Context, configWriter Config
 		certs:     make(chan types.CertificateAndKey),
 		rootCerts: make(chan types.Certificates),
 
+		proxyDeregistered: make(chan struct{}),
+
 		configWriter: configWriter,
 	}
 	c.c = client
@@ -113,21 +131,33 @@ func (c *certificateFetcher) getProxyConfig(ctx context.Context, proxyid string)
 			if ctx.Err() != nil {
 				return
 			}
-			// TODO: log error...
+			if IsNotFound(err) {
+				log.Printf("proxy %v was deregistered", proxyid)
+				close(c.proxyDeregistered)
+				return
+			}
+			log.Warnf("failed to get proxy config: %v", err)
 			time.Sleep(time.Second)
 			continue
 		}
 		q = &api.QueryOptions{
 			WaitIndex: query.LastIndex,
 		}
-		c.configWriter.Write(proxyinfo)
+		// the config writer retries failed writes itself
+		if err := c.configWriter.Write(proxyinfo); err != nil {
+			log.Warnf("failed to write proxy config: %v", err)
+		}
 		if !leafStarted {
 			go c.getLeaf(ctx, proxyinfo.TargetServiceName)
 			leafStarted = true
 		}
 		if query.LastIndex == 0 {
-			// if this is not a blocking query, exit
-			return
+			// the agent gave us no index to block on, so poll instead
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(proxyConfigPollInterval):
+			}
 		}
 	}
 }
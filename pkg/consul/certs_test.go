@@ -50,7 +50,12 @@ func (c *mockConnectClient) ConnectCALeaf(svcname string, q *api.QueryOptions) (
 }
 
 func (c *mockConnectClient) ConnectProxyConfig(proxyid string, q *api.QueryOptions) (*api.ConnectProxyConfig, *api.QueryMeta, error) {
-	return <-c.pconfigchan, generateQm(), nil
+	pcfg := <-c.pconfigchan
+	if pcfg == nil {
+		// the proxy was deregistered
+		return nil, nil, &StatusError{StatusCode: 404, Body: "unknown proxy service ID: 123"}
+	}
+	return pcfg, generateQm(), nil
 }
 
 var _ = Describe("Certs", func() {
@@ -128,4 +133,15 @@ var _ = Describe("Certs", func() {
 		})
 
 	})
+
+	Context("proxy config", func() {
+
+		It("should report when the proxy is deregistered", func() {
+			mockClient.pconfigchan <- &api.ConnectProxyConfig{ProxyServiceID: "123", TargetServiceName: "web"}
+			Consistently(certificateFetcher.ProxyDeregistered()).ShouldNot(BeClosed())
+			mockClient.pconfigchan <- nil
+			Eventually(certificateFetcher.ProxyDeregistered()).Should(BeClosed())
+		})
+
+	})
 })
@@ -2,7 +2,12 @@ package consul
 
 import (
 	"errors"
+	"fmt"
+	"net"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/mitchellh/mapstructure"
 
@@ -18,13 +23,151 @@ type ProxyConfig struct {
 	BindAddress         string     `json:"bind_address" mapstructure:"bind_address"`
 	BindPort            uint       `json:"bind_port" mapstructure:"bind_port"`
 	LocalServiceAddress string     `json:"local_service_address" mapstructure:"local_service_address"`
+	ClientOnly          bool       `json:"client_only" mapstructure:"client_only"`
 	Upstreams           []Upstream `json:"upstreams" mapstructure:"upstreams"`
+
+	AuthorizeMode          string `json:"authorize_mode" mapstructure:"authorize_mode"`
+	AuthorizeTimeoutMs     uint   `json:"authorize_timeout_ms" mapstructure:"authorize_timeout_ms"`
+	AuthorizeFailurePolicy string `json:"authorize_failure_policy" mapstructure:"authorize_failure_policy"`
+}
+
+const DefaultAuthorizeTimeout = time.Second
+
+// AuthorizeTimeout returns how long inbound connections wait for an
+// authorization decision
+func (c *ProxyConfig) AuthorizeTimeout() time.Duration {
+	if c.AuthorizeTimeoutMs == 0 {
+		return DefaultAuthorizeTimeout
+	}
+	return time.Duration(c.AuthorizeTimeoutMs) * time.Millisecond
 }
 
+// IsClientOnly returns true if the proxy should only get outbound listeners.
+// This is either set explicitly, or detected when the proxy has no port to
+// accept inbound traffic on and no local service to forward it to.
+func (c *ProxyConfig) IsClientOnly() bool {
+	return c.ClientOnly || (c.BindPort == 0 && c.LocalServiceAddress == "")
+}
+
+const (
+	UpstreamDestinationTypeService       = "service"
+	UpstreamDestinationTypePreparedQuery = "prepared_query"
+)
+
 type Upstream struct {
-	DestinationName string `json:"destination_name" mapstructure:"destination_name"`
-	DestinationType string `json:"destination_type" mapstructure:"destination_type"`
-	LocalBindPort   uint32 `json:"local_bind_port" mapstructure:"local_bind_port"`
+	DestinationName  string         `json:"destination_name" mapstructure:"destination_name"`
+	DestinationType  string         `json:"destination_type" mapstructure:"destination_type"`
+	Datacenter       string         `json:"datacenter" mapstructure:"datacenter"`
+	LocalBindAddress string         `json:"local_bind_address" mapstructure:"local_bind_address"`
+	LocalBindPort    uint32         `json:"local_bind_port" mapstructure:"local_bind_port"`
+	Config           UpstreamConfig `json:"config" mapstructure:"config"`
+}
+
+const (
+	ProtocolTCP   = "tcp"
+	ProtocolHTTP  = "http"
+	ProtocolHTTP2 = "http2"
+	ProtocolGRPC  = "grpc"
+)
+
+// UpstreamConfig holds the per-upstream settings from the upstream's config block
+type UpstreamConfig struct {
+	ConnectTimeoutMs uint           `json:"connect_timeout_ms" mapstructure:"connect_timeout_ms"`
+	Protocol         string         `json:"protocol" mapstructure:"protocol"`
+	LocalBindAddress string         `json:"local_bind_address" mapstructure:"local_bind_address"`
+	Limits           UpstreamLimits `json:"limits" mapstructure:"limits"`
+}
+
+// UpstreamLimits are circuit breaker limits for the upstream's cluster
+type UpstreamLimits struct {
+	MaxConnections     uint32 `json:"max_connections" mapstructure:"max_connections"`
+	MaxPendingRequests uint32 `json:"max_pending_requests" mapstructure:"max_pending_requests"`
+	MaxRequests        uint32 `json:"max_requests" mapstructure:"max_requests"`
+	MaxRetries         uint32 `json:"max_retries" mapstructure:"max_retries"`
+}
+
+func (l *UpstreamLimits) IsEmpty() bool {
+	return *l == UpstreamLimits{}
+}
+
+func (c *UpstreamConfig) ConnectTimeout() time.Duration {
+	return time.Duration(c.ConnectTimeoutMs) * time.Millisecond
+}
+
+const DefaultLocalBindAddress = "127.0.0.1"
+
+// BindAddress is where the application reaches the upstream: an ip address
+// (v4 or v6), or a unix domain socket as unix:///path/to/socket. Consul has a
+// local_bind_address field of its own; the one in the config block is only
+// used if that is empty (Validate rejects both being set to different values).
+func (u *Upstream) BindAddress() string {
+	if u.LocalBindAddress != "" {
+		return u.LocalBindAddress
+	}
+	if u.Config.LocalBindAddress != "" {
+		return u.Config.LocalBindAddress
+	}
+	return DefaultLocalBindAddress
+}
+
+const unixSocketPrefix = "unix://"
+
+// UnixSocketPath returns the socket path of a unix:///path/to/socket address
+func UnixSocketPath(address string) (string, bool) {
+	if !strings.HasPrefix(address, unixSocketPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(address, unixSocketPrefix), true
+}
+
+// NeedsResolution returns true if the bridge has to resolve the upstream's
+// endpoints itself. Upstream discovery only knows the services of the local
+// datacenter.
+func (u *Upstream) NeedsResolution() bool {
+	return u.DestinationType == UpstreamDestinationTypePreparedQuery || u.Datacenter != ""
+}
+
+// Name is a unique name for the upstream. Services in the local datacenter
+// keep their name, so they match the upstreams created by discovery.
+func (u *Upstream) Name() string {
+	name := u.DestinationName
+	if u.DestinationType == UpstreamDestinationTypePreparedQuery {
+		name += ".query"
+	}
+	if u.Datacenter != "" {
+		name += "." + u.Datacenter
+	}
+	return name
+}
+
+func (u *Upstream) Validate() error {
+	if u.DestinationName == "" {
+		return errors.New("upstream destination_name is required")
+	}
+	switch u.DestinationType {
+	case "", UpstreamDestinationTypeService, UpstreamDestinationTypePreparedQuery:
+	default:
+		return fmt.Errorf("upstream %v has invalid destination_type %q, must be %q or %q",
+			u.DestinationName, u.DestinationType, UpstreamDestinationTypeService, UpstreamDestinationTypePreparedQuery)
+	}
+	if u.LocalBindAddress != "" && u.Config.LocalBindAddress != "" && u.LocalBindAddress != u.Config.LocalBindAddress {
+		return fmt.Errorf("upstream %v sets local_bind_address to %q and to %q in its config block, set only one",
+			u.DestinationName, u.LocalBindAddress, u.Config.LocalBindAddress)
+	}
+	if path, ok := UnixSocketPath(u.BindAddress()); ok {
+		if !filepath.IsAbs(path) {
+			return fmt.Errorf("upstream %v: unix socket path %q must be absolute", u.DestinationName, path)
+		}
+	} else if net.ParseIP(u.BindAddress()) == nil {
+		return fmt.Errorf("upstream %v: invalid local bind address %q", u.DestinationName, u.BindAddress())
+	}
+	switch u.Config.Protocol {
+	case "", ProtocolTCP, ProtocolHTTP, ProtocolHTTP2, ProtocolGRPC:
+	default:
+		return fmt.Errorf("upstream %v has invalid protocol %q, must be one of %v", u.DestinationName, u.Config.Protocol,
+			[]string{ProtocolTCP, ProtocolHTTP, ProtocolHTTP2, ProtocolGRPC})
+	}
+	return nil
 }
 
 type consulConnectConfig struct {
@@ -53,5 +196,13 @@ func GetProxyConfig(pcfg *api.ConnectProxyConfig) (*ProxyConfig, error) {
 	if err != nil {
 		return nil, err
 	}
+	if path, ok := UnixSocketPath(cfg.LocalServiceAddress); ok && !filepath.IsAbs(path) {
+		return nil, fmt.Errorf("local_service_address: unix socket path %q must be absolute", path)
+	}
+	for _, upstream := range cfg.Upstreams {
+		if err := upstream.Validate(); err != nil {
+			return nil, err
+		}
+	}
 	return cfg, nil
 }
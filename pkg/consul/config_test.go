@@ -27,4 +27,50 @@ var _ = Describe("Config", func() {
 		Expect(cfg.BindPort).NotTo(BeZero())
 		Expect(cfg.BindAddress).NotTo(BeEmpty())
 	})
+
+	It("should detect client-only proxies", func() {
+		Expect((&ProxyConfig{BindPort: 20000, LocalServiceAddress: "127.0.0.1:8080"}).IsClientOnly()).To(BeFalse())
+		Expect((&ProxyConfig{}).IsClientOnly()).To(BeTrue())
+		Expect((&ProxyConfig{BindPort: 20000, ClientOnly: true}).IsClientOnly()).To(BeTrue())
+	})
+
+	It("should name upstreams that need resolution uniquely", func() {
+		local := Upstream{DestinationName: "db"}
+		Expect(local.NeedsResolution()).To(BeFalse())
+		Expect(local.Name()).To(Equal("db"))
+
+		remote := Upstream{DestinationName: "db", Datacenter: "dc2"}
+		Expect(remote.NeedsResolution()).To(BeTrue())
+		Expect(remote.Name()).To(Equal("db.dc2"))
+
+		query := Upstream{DestinationName: "db", DestinationType: "prepared_query"}
+		Expect(query.NeedsResolution()).To(BeTrue())
+		Expect(query.Name()).To(Equal("db.query"))
+	})
+
+	It("should reject unknown destination types", func() {
+		pcfg := &api.ConnectProxyConfig{
+			Config: map[string]interface{}{
+				"upstreams": []interface{}{
+					map[string]interface{}{
+						"destination_name": "db",
+						"destination_type": "bogus",
+					},
+				},
+			},
+		}
+		_, err := GetProxyConfig(pcfg)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should validate upstream bind addresses", func() {
+		Expect((&Upstream{DestinationName: "db"}).BindAddress()).To(Equal("127.0.0.1"))
+		Expect((&Upstream{DestinationName: "db", LocalBindAddress: "::1"}).Validate()).NotTo(HaveOccurred())
+		Expect((&Upstream{DestinationName: "db", LocalBindAddress: "localhost"}).Validate()).To(HaveOccurred())
+		Expect((&Upstream{DestinationName: "db", LocalBindAddress: "unix:///tmp/db.sock", LocalBindPort: 9191}).Validate()).NotTo(HaveOccurred())
+		Expect((&Upstream{DestinationName: "db", LocalBindAddress: "unix://db.sock", LocalBindPort: 9191}).Validate()).To(HaveOccurred())
+		Expect((&Upstream{DestinationName: "db", LocalBindAddress: "unix:///tmp/db.sock"}).Validate()).NotTo(HaveOccurred())
+		Expect((&Upstream{DestinationName: "db", LocalBindAddress: "::1", Config: UpstreamConfig{LocalBindAddress: "::1"}}).Validate()).NotTo(HaveOccurred())
+		Expect((&Upstream{DestinationName: "db", LocalBindAddress: "::1", Config: UpstreamConfig{LocalBindAddress: "0.0.0.0"}}).Validate()).To(HaveOccurred())
+	})
 })
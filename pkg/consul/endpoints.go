@@ -0,0 +1,174 @@
+package consul
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/solo-io/gloo/pkg/log"
+)
+
+// prepared queries can't be watched with a blocking query, so the service a
+// query resolved to is watched instead, and the query executed again whenever
+// that service changes. The watch is bounded so a query that failed over to
+// another datacenter notices when its own recovers.
+const preparedQueryWaitTime = time.Minute
+
+type HealthClient interface {
+	Connect(service, tag string, passingOnly bool, q *api.QueryOptions) ([]*api.ServiceEntry, *api.QueryMeta, error)
+}
+
+type PreparedQueryClient interface {
+	Execute(queryIDOrName string, q *api.QueryOptions) (*api.PreparedQueryExecuteResponse, *api.QueryMeta, error)
+}
+
+type Endpoint struct {
+	Address string
+	Port    uint32
+}
+
+// WatchEndpoints resolves the connect-capable endpoints of an upstream that
+// upstream discovery can't resolve (see Upstream.NeedsResolution), and calls
+// onUpdate whenever they change, until ctx is cancelled.
+func WatchEndpoints(ctx context.Context, health HealthClient, queries PreparedQueryClient, upstream Upstream, onUpdate func([]Endpoint)) {
+	var current []Endpoint
+	update := func(entries []*api.ServiceEntry) {
+		endpoints := endpointsFromEntries(entries)
+		if current != nil && reflect.DeepEqual(endpoints, current) {
+			return
+		}
+		current = endpoints
+		onUpdate(endpoints)
+	}
+	if upstream.DestinationType == UpstreamDestinationTypePreparedQuery {
+		watchPreparedQuery(ctx, health, queries, upstream, update)
+		return
+	}
+	watchService(ctx, health, upstream, update)
+}
+
+func watchService(ctx context.Context, health HealthClient, upstream Upstream, update func([]*api.ServiceEntry)) {
+	q := &api.QueryOptions{Datacenter: upstream.Datacenter}
+	for {
+		q = q.WithContext(ctx)
+		entries, query, err := health.Connect(upstream.DestinationName, "", true, q)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Warnf("failed to resolve %v: %v", upstream.Name(), err)
+			if !sleep(ctx, time.Second) {
+				return
+			}
+			continue
+		}
+		q = &api.QueryOptions{
+			Datacenter: upstream.Datacenter,
+			WaitIndex:  query.LastIndex,
+		}
+		update(entries)
+	}
+}
+
+func watchPreparedQuery(ctx context.Context, health HealthClient, queries PreparedQueryClient, upstream Upstream, update func([]*api.ServiceEntry)) {
+	var (
+		lastDatacenter string
+		watched        string
+		waitIndex      uint64
+	)
+	for {
+		q := (&api.QueryOptions{Datacenter: upstream.Datacenter}).WithContext(ctx)
+		resp, _, err := queries.Execute(upstream.DestinationName, q)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Warnf("failed to execute prepared query %v: %v", upstream.DestinationName, err)
+			if !sleep(ctx, time.Second) {
+				return
+			}
+			continue
+		}
+		// consul fails over to other datacenters as configured in the query
+		if resp.Datacenter != lastDatacenter {
+			log.Printf("prepared query %v resolved to datacenter %v", upstream.DestinationName, resp.Datacenter)
+			lastDatacenter = resp.Datacenter
+		}
+		update(connectEntries(upstream.DestinationName, resp.Nodes))
+
+		if target := resp.Datacenter + "/" + resp.Service; target != watched {
+			watched, waitIndex = target, 0
+		}
+		q = (&api.QueryOptions{
+			Datacenter: resp.Datacenter,
+			WaitIndex:  waitIndex,
+			WaitTime:   preparedQueryWaitTime,
+		}).WithContext(ctx)
+		_, meta, err := health.Connect(resp.Service, "", false, q)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Warnf("failed to watch %v for prepared query %v: %v", resp.Service, upstream.DestinationName, err)
+			if !sleep(ctx, time.Second) {
+				return
+			}
+			continue
+		}
+		waitIndex = meta.LastIndex
+	}
+}
+
+// connectEntries drops the results of a query that isn't connect enabled:
+// their endpoints don't accept connect's mutual tls
+func connectEntries(query string, nodes []api.ServiceEntry) []*api.ServiceEntry {
+	entries := make([]*api.ServiceEntry, 0, len(nodes))
+	for i := range nodes {
+		service := nodes[i].Service
+		if service == nil {
+			continue
+		}
+		if service.Kind != api.ServiceKindConnectProxy && (service.Connect == nil || !service.Connect.Native) {
+			log.Warnf("prepared query %v returned %v, which isn't connect enabled; set Connect on the query's service", query, service.Service)
+			continue
+		}
+		entries = append(entries, &nodes[i])
+	}
+	return entries
+}
+
+// sleep returns false if ctx was cancelled first
+func sleep(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+func endpointsFromEntries(entries []*api.ServiceEntry) []Endpoint {
+	endpoints := []Endpoint{}
+	for _, entry := range entries {
+		if entry.Service == nil {
+			continue
+		}
+		address := entry.Service.Address
+		if address == "" && entry.Node != nil {
+			address = entry.Node.Address
+		}
+		endpoints = append(endpoints, Endpoint{
+			Address: address,
+			Port:    uint32(entry.Service.Port),
+		})
+	}
+	sort.SliceStable(endpoints, func(i, j int) bool {
+		if endpoints[i].Address != endpoints[j].Address {
+			return endpoints[i].Address < endpoints[j].Address
+		}
+		return endpoints[i].Port < endpoints[j].Port
+	})
+	return endpoints
+}
@@ -0,0 +1,114 @@
+package consul_test
+
+import (
+	"context"
+
+	"github.com/hashicorp/consul/api"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/solo-io/gloo-connect/pkg/consul"
+)
+
+type mockHealthClient struct {
+	entries chan []*api.ServiceEntry
+	queries chan *api.QueryOptions
+}
+
+func (c *mockHealthClient) Connect(service, tag string, passingOnly bool, q *api.QueryOptions) ([]*api.ServiceEntry, *api.QueryMeta, error) {
+	c.queries <- q
+	select {
+	case entries := <-c.entries:
+		return entries, generateQm(), nil
+	case <-q.Context().Done():
+		return nil, nil, q.Context().Err()
+	}
+}
+
+func (c *mockHealthClient) Service(service, tag string, passingOnly bool, q *api.QueryOptions) ([]*api.ServiceEntry, *api.QueryMeta, error) {
+	return c.Connect(service, tag, passingOnly, q)
+}
+
+type mockPreparedQueryClient struct {
+	responses chan *api.PreparedQueryExecuteResponse
+}
+
+func (c *mockPreparedQueryClient) Execute(queryIDOrName string, q *api.QueryOptions) (*api.PreparedQueryExecuteResponse, *api.QueryMeta, error) {
+	select {
+	case resp := <-c.responses:
+		return resp, generateQm(), nil
+	case <-q.Context().Done():
+		return nil, nil, q.Context().Err()
+	}
+}
+
+var _ = Describe("Endpoints", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		health *mockHealthClient
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithCancel(context.Background())
+		health = &mockHealthClient{
+			entries: make(chan []*api.ServiceEntry, 10),
+			queries: make(chan *api.QueryOptions, 10),
+		}
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	It("should resolve services in other datacenters", func() {
+		endpoints := make(chan []Endpoint, 10)
+		go WatchEndpoints(ctx, health, nil, Upstream{DestinationName: "db", Datacenter: "dc2"}, func(e []Endpoint) {
+			endpoints <- e
+		})
+
+		var q *api.QueryOptions
+		Eventually(health.queries).Should(Receive(&q))
+		Expect(q.Datacenter).To(Equal("dc2"))
+
+		health.entries <- []*api.ServiceEntry{{
+			Node:    &api.Node{Address: "10.0.0.1"},
+			Service: &api.AgentService{Port: 20000},
+		}}
+		Eventually(endpoints).Should(Receive(Equal([]Endpoint{{Address: "10.0.0.1", Port: 20000}})))
+	})
+
+	It("should execute prepared queries again when the resolved service changes", func() {
+		queries := &mockPreparedQueryClient{responses: make(chan *api.PreparedQueryExecuteResponse, 10)}
+		endpoints := make(chan []Endpoint, 10)
+		go WatchEndpoints(ctx, health, queries, Upstream{
+			DestinationType: UpstreamDestinationTypePreparedQuery,
+			DestinationName: "db-query",
+		}, func(e []Endpoint) {
+			endpoints <- e
+		})
+
+		queries.responses <- &api.PreparedQueryExecuteResponse{
+			Service:    "db",
+			Datacenter: "dc2",
+			Nodes: []api.ServiceEntry{{
+				Node:    &api.Node{Address: "10.0.0.1"},
+				Service: &api.AgentService{Service: "db-proxy", Kind: api.ServiceKindConnectProxy, Port: 20000},
+			}, {
+				Node:    &api.Node{Address: "10.0.0.2"},
+				Service: &api.AgentService{Service: "db", Port: 5432},
+			}},
+		}
+		Eventually(endpoints).Should(Receive(Equal([]Endpoint{{Address: "10.0.0.1", Port: 20000}})))
+
+		var q *api.QueryOptions
+		Eventually(health.queries).Should(Receive(&q))
+		Expect(q.Datacenter).To(Equal("dc2"))
+		Expect(q.WaitTime).NotTo(BeZero())
+
+		queries.responses <- &api.PreparedQueryExecuteResponse{Service: "db", Datacenter: "dc2"}
+		health.entries <- nil
+		Eventually(endpoints).Should(Receive(BeEmpty()))
+	})
+})
@@ -0,0 +1,68 @@
+package consul
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/solo-io/gloo/pkg/log"
+)
+
+type ServiceHealthClient interface {
+	Service(service, tag string, passingOnly bool, q *api.QueryOptions) ([]*api.ServiceEntry, *api.QueryMeta, error)
+}
+
+// WatchServiceHealth watches the health checks of one service instance, and
+// calls onUpdate whenever the instance becomes healthy or unhealthy, until
+// ctx is cancelled. The first result is always reported.
+func WatchServiceHealth(ctx context.Context, health ServiceHealthClient, node, service, serviceID string, onUpdate func(healthy bool)) {
+	var (
+		q        *api.QueryOptions
+		reported bool
+		current  bool
+	)
+	for {
+		q = q.WithContext(ctx)
+		entries, query, err := health.Service(service, "", false, q)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Warnf("failed to get health of %v: %v", serviceID, err)
+			time.Sleep(time.Second)
+			continue
+		}
+		q = &api.QueryOptions{
+			WaitIndex: query.LastIndex,
+		}
+		healthy := instanceHealthy(entries, node, serviceID)
+		if reported && healthy == current {
+			continue
+		}
+		reported = true
+		current = healthy
+		onUpdate(healthy)
+	}
+}
+
+// instanceHealthy returns false if any check of the instance is critical,
+// or if the instance isn't registered. checks in warning still get traffic,
+// as they do in consul dns.
+func instanceHealthy(entries []*api.ServiceEntry, node, serviceID string) bool {
+	for _, entry := range entries {
+		if entry.Service == nil || entry.Service.ID != serviceID {
+			continue
+		}
+		// service ids are only unique per node
+		if node != "" && entry.Node != nil && entry.Node.Node != node {
+			continue
+		}
+		for _, check := range entry.Checks {
+			if check.Status == api.HealthCritical {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+package consul_test
+
+import (
+	"context"
+
+	"github.com/hashicorp/consul/api"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/solo-io/gloo-connect/pkg/consul"
+)
+
+var _ = Describe("Health", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		health *mockHealthClient
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithCancel(context.Background())
+		health = &mockHealthClient{
+			entries: make(chan []*api.ServiceEntry, 10),
+			queries: make(chan *api.QueryOptions, 10),
+		}
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	entry := func(node, id, status string) *api.ServiceEntry {
+		return &api.ServiceEntry{
+			Node:    &api.Node{Node: node},
+			Service: &api.AgentService{ID: id, Service: "web"},
+			Checks:  api.HealthChecks{{Status: status}},
+		}
+	}
+
+	It("should report changes in the health of one instance", func() {
+		updates := make(chan bool, 10)
+		go WatchServiceHealth(ctx, health, "node1", "web", "web1", func(healthy bool) {
+			updates <- healthy
+		})
+
+		health.entries <- []*api.ServiceEntry{entry("node1", "web1", api.HealthPassing), entry("node2", "web1", api.HealthCritical)}
+		Eventually(updates).Should(Receive(BeTrue()))
+
+		// warnings still get traffic
+		health.entries <- []*api.ServiceEntry{entry("node1", "web1", api.HealthWarning)}
+		health.entries <- []*api.ServiceEntry{entry("node1", "web1", api.HealthCritical)}
+		Eventually(updates).Should(Receive(BeFalse()))
+
+		// a deregistered instance gets no traffic either
+		health.entries <- []*api.ServiceEntry{entry("node1", "web1", api.HealthPassing)}
+		Eventually(updates).Should(Receive(BeTrue()))
+		health.entries <- []*api.ServiceEntry{}
+		Eventually(updates).Should(Receive(BeFalse()))
+	})
+})
@@ -0,0 +1,64 @@
+package consul
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/pkg/errors"
+	"github.com/solo-io/gloo/pkg/log"
+)
+
+type CheckClient interface {
+	CheckRegister(check *api.AgentCheckRegistration) error
+	CheckDeregister(checkID string) error
+	UpdateTTL(checkID, output, status string) error
+}
+
+// ProxyCheckID is the id of the ttl check the bridge registers on the proxy service
+func ProxyCheckID(proxyServiceID string) string {
+	return "gloo-connect:" + proxyServiceID
+}
+
+// RunProxyCheck registers a ttl check on the proxy service, and updates it
+// with the result of probe well within the ttl, so consul stops routing
+// traffic to the proxy when it is broken or gone. The check is deregistered
+// when ctx is cancelled.
+func RunProxyCheck(ctx context.Context, agent CheckClient, proxyServiceID string, ttl time.Duration, probe func() error) error {
+	checkID := ProxyCheckID(proxyServiceID)
+	err := agent.CheckRegister(&api.AgentCheckRegistration{
+		ID:        checkID,
+		Name:      "gloo-connect bridge",
+		ServiceID: proxyServiceID,
+		Notes:     "passes while the certificates are current, the role is synced and envoy is live",
+		AgentServiceCheck: api.AgentServiceCheck{
+			TTL:    ttl.String(),
+			Status: api.HealthCritical,
+		},
+	})
+	if err != nil {
+		return errors.Wrapf(err, "registering check %v", checkID)
+	}
+	defer func() {
+		if err := agent.CheckDeregister(checkID); err != nil {
+			log.Warnf("failed to deregister check %v: %v", checkID, err)
+		}
+	}()
+
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+	for {
+		status, output := api.HealthPassing, "ok"
+		if err := probe(); err != nil {
+			status, output = api.HealthCritical, err.Error()
+		}
+		if err := agent.UpdateTTL(checkID, output, status); err != nil {
+			log.Warnf("failed to update check %v: %v", checkID, err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package consul_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/solo-io/gloo-connect/pkg/consul"
+)
+
+type fakeCheckClient struct {
+	registered   chan *api.AgentCheckRegistration
+	updates      chan string
+	deregistered chan string
+}
+
+func (c *fakeCheckClient) CheckRegister(check *api.AgentCheckRegistration) error {
+	c.registered <- check
+	return nil
+}
+
+func (c *fakeCheckClient) CheckDeregister(checkID string) error {
+	c.deregistered <- checkID
+	return nil
+}
+
+func (c *fakeCheckClient) UpdateTTL(checkID, output, status string) error {
+	c.updates <- status
+	return nil
+}
+
+var _ = Describe("ProxyCheck", func() {
+	It("should report the probe result until cancelled", func() {
+		agent := &fakeCheckClient{
+			registered:   make(chan *api.AgentCheckRegistration, 1),
+			updates:      make(chan string, 100),
+			deregistered: make(chan string, 1),
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		probeErr := make(chan error, 1)
+		probeErr <- errors.New("envoy is not live")
+		probe := func() error {
+			select {
+			case err := <-probeErr:
+				return err
+			default:
+				return nil
+			}
+		}
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			RunProxyCheck(ctx, agent, "web-proxy", 30*time.Millisecond, probe)
+		}()
+
+		var check *api.AgentCheckRegistration
+		Eventually(agent.registered).Should(Receive(&check))
+		Expect(check.ServiceID).To(Equal("web-proxy"))
+		Expect(check.TTL).To(Equal("30ms"))
+		Eventually(agent.updates).Should(Receive(Equal(api.HealthCritical)))
+		Eventually(agent.updates).Should(Receive(Equal(api.HealthPassing)))
+
+		cancel()
+		Eventually(done).Should(BeClosed())
+		Expect(agent.deregistered).To(Receive(Equal(ProxyCheckID("web-proxy"))))
+	})
+})
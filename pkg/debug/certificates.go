@@ -0,0 +1,77 @@
+package debug
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"sort"
+	"time"
+
+	"github.com/solo-io/gloo/pkg/api/defaults/v1"
+	"github.com/solo-io/gloo/pkg/storage/dependencies"
+)
+
+// CertificateInfo is what support needs to know about a certificate, without
+// the certificate itself
+type CertificateInfo struct {
+	Secret       string    `json:"secret"`
+	Key          string    `json:"key"`
+	Subject      string    `json:"subject"`
+	Issuer       string    `json:"issuer"`
+	SerialNumber string    `json:"serial_number"`
+	NotBefore    time.Time `json:"not_before"`
+	NotAfter     time.Time `json:"not_after"`
+	URIs         []string  `json:"uris,omitempty"`
+	DNSNames     []string  `json:"dns_names,omitempty"`
+	IsCA         bool      `json:"is_ca"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// certificateInfos describes the certificate chains and root cas in secrets
+func certificateInfos(secrets []*dependencies.Secret) []CertificateInfo {
+	infos := []CertificateInfo{}
+	for _, secret := range secrets {
+		var keys []string
+		for key := range secret.Data {
+			if key == v1.SslCertificateChainKey || key == v1.SslRootCaKey {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			infos = append(infos, parseCertificates(secret.Ref, key, []byte(secret.Data[key]))...)
+		}
+	}
+	return infos
+}
+
+func parseCertificates(secret, key string, data []byte) []CertificateInfo {
+	var infos []CertificateInfo
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return infos
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		info := CertificateInfo{Secret: secret, Key: key}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			info.Error = err.Error()
+			infos = append(infos, info)
+			continue
+		}
+		info.Subject = cert.Subject.String()
+		info.Issuer = cert.Issuer.String()
+		info.SerialNumber = cert.SerialNumber.String()
+		info.NotBefore = cert.NotBefore
+		info.NotAfter = cert.NotAfter
+		info.DNSNames = cert.DNSNames
+		info.IsCA = cert.IsCA
+		for _, uri := range cert.URIs {
+			info.URIs = append(info.URIs, uri.String())
+		}
+		infos = append(infos, info)
+	}
+}
@@ -0,0 +1,271 @@
+package debug
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gogo/protobuf/jsonpb"
+	"github.com/gogo/protobuf/proto"
+	"github.com/hashicorp/consul/api"
+	"github.com/pkg/errors"
+	"github.com/solo-io/gloo-connect/pkg/status"
+	"github.com/solo-io/gloo/pkg/api/defaults/v1"
+	"github.com/solo-io/gloo/pkg/storage"
+	"github.com/solo-io/gloo/pkg/storage/dependencies"
+)
+
+const (
+	DumpPath         = "/debug/dump"
+	StatusPath       = "/debug/status"
+	CertificatesPath = "/debug/certificates"
+	LogsPath         = "/debug/logs"
+	EnvoyLogsPath    = "/debug/envoy-logs"
+	// followed by one of EnvoyAdminPaths
+	EnvoyAdminPath = "/debug/envoy/"
+	// where `gloo-connect debug` looks for the bridge by default
+	DefaultAddress = "127.0.0.1:19100"
+
+	redacted = "<redacted>"
+)
+
+// EnvoyAdminPaths are the paths of envoy's admin api the debug server passes through
+var EnvoyAdminPaths = []string{"config_dump", "clusters", "stats", "server_info"}
+
+// envoy inlines the certificates of the listeners in its config dump
+var privateKeyPattern = regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`)
+
+// Sources are the parts of the bridge the debug server reads from. All of
+// them are optional.
+type Sources struct {
+	Config  storage.Interface
+	Secrets dependencies.SecretStorage
+	// returns the envoy bootstrap config as json
+	EnvoyBootstrap func() string
+	// returns the latest proxy config received from consul
+	ProxyConfig func() *api.ConnectProxyConfig
+	Status      *status.Status
+	// gets a path of envoy's admin api
+	EnvoyAdmin func(path string) ([]byte, error)
+	// what the bridge logged
+	Logs *LogBuffer
+	// what envoy wrote to stdout and stderr
+	EnvoyLogs *LogBuffer
+}
+
+// Dump is what the bridge holds in memory. Gloo objects are in the json
+// format of their protos.
+type Dump struct {
+	Roles          []json.RawMessage       `json:"roles"`
+	Upstreams      []json.RawMessage       `json:"upstreams"`
+	Secrets        []*Secret               `json:"secrets"`
+	EnvoyBootstrap json.RawMessage         `json:"envoy_bootstrap,omitempty"`
+	ProxyConfig    *api.ConnectProxyConfig `json:"proxy_config,omitempty"`
+	Status         *status.Snapshot        `json:"status,omitempty"`
+}
+
+// Secret is a secret with its private key redacted
+type Secret struct {
+	Ref  string            `json:"ref"`
+	Data map[string]string `json:"data"`
+}
+
+type Server struct {
+	sources Sources
+}
+
+func NewServer(sources Sources) *Server {
+	return &Server{sources: sources}
+}
+
+// ValidateAddress only accepts loopback addresses, since the dump exposes the
+// bridge's whole config
+func ValidateAddress(address string) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return errors.Wrapf(err, "invalid debug address %v", address)
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return errors.Errorf("debug address %v must be a loopback address", address)
+	}
+	return nil
+}
+
+func (s *Server) Dump() (*Dump, error) {
+	dump := &Dump{
+		Roles:     []json.RawMessage{},
+		Upstreams: []json.RawMessage{},
+		Secrets:   []*Secret{},
+	}
+	if s.sources.Config != nil {
+		roles, err := s.sources.Config.V1().Roles().List()
+		if err != nil {
+			return nil, errors.Wrap(err, "listing roles")
+		}
+		for _, role := range roles {
+			raw, err := marshalProto(role)
+			if err != nil {
+				return nil, err
+			}
+			dump.Roles = append(dump.Roles, raw)
+		}
+		upstreams, err := s.sources.Config.V1().Upstreams().List()
+		if err != nil {
+			return nil, errors.Wrap(err, "listing upstreams")
+		}
+		for _, us := range upstreams {
+			raw, err := marshalProto(us)
+			if err != nil {
+				return nil, err
+			}
+			dump.Upstreams = append(dump.Upstreams, raw)
+		}
+	}
+	if s.sources.Secrets != nil {
+		secrets, err := s.sources.Secrets.List()
+		if err != nil {
+			return nil, errors.Wrap(err, "listing secrets")
+		}
+		for _, secret := range secrets {
+			dump.Secrets = append(dump.Secrets, redact(secret))
+		}
+	}
+	if s.sources.EnvoyBootstrap != nil {
+		if bootstrap := s.sources.EnvoyBootstrap(); bootstrap != "" {
+			dump.EnvoyBootstrap = json.RawMessage(bootstrap)
+		}
+	}
+	if s.sources.ProxyConfig != nil {
+		dump.ProxyConfig = s.sources.ProxyConfig()
+	}
+	if s.sources.Status != nil {
+		snapshot := s.sources.Status.Get()
+		dump.Status = &snapshot
+	}
+	return dump, nil
+}
+
+func marshalProto(msg proto.Message) (json.RawMessage, error) {
+	var buf bytes.Buffer
+	if err := (&jsonpb.Marshaler{OrigName: true}).Marshal(&buf, msg); err != nil {
+		return nil, err
+	}
+	return json.RawMessage(buf.Bytes()), nil
+}
+
+func redact(secret *dependencies.Secret) *Secret {
+	out := &Secret{
+		Ref:  secret.Ref,
+		Data: make(map[string]string),
+	}
+	for key, value := range secret.Data {
+		if key == v1.SslPrivateKeyKey || strings.Contains(value, "PRIVATE KEY") {
+			value = redacted
+		}
+		out.Data[key] = value
+	}
+	return out
+}
+
+// Serve serves the debug endpoints on the listener until the context is cancelled
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc(DumpPath, s.handleDump)
+	mux.HandleFunc(StatusPath, s.handleStatus)
+	mux.HandleFunc(CertificatesPath, s.handleCertificates)
+	mux.HandleFunc(LogsPath, handleLogs(s.sources.Logs))
+	mux.HandleFunc(EnvoyLogsPath, handleLogs(s.sources.EnvoyLogs))
+	mux.HandleFunc(EnvoyAdminPath, s.handleEnvoyAdmin)
+	srv := &http.Server{Handler: mux}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	if err := srv.Serve(listener); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+func (s *Server) handleDump(rw http.ResponseWriter, r *http.Request) {
+	dump, err := s.Dump()
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(rw, dump)
+}
+
+func (s *Server) handleStatus(rw http.ResponseWriter, r *http.Request) {
+	if s.sources.Status == nil {
+		http.NotFound(rw, r)
+		return
+	}
+	writeJSON(rw, s.sources.Status.Get())
+}
+
+func (s *Server) handleCertificates(rw http.ResponseWriter, r *http.Request) {
+	if s.sources.Secrets == nil {
+		http.NotFound(rw, r)
+		return
+	}
+	secrets, err := s.sources.Secrets.List()
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(rw, certificateInfos(secrets))
+}
+
+func handleLogs(logs *LogBuffer) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if logs == nil {
+			http.NotFound(rw, r)
+			return
+		}
+		rw.Header().Set("Content-Type", "text/plain")
+		rw.Write(RedactPrivateKeys(logs.Bytes()))
+	}
+}
+
+func (s *Server) handleEnvoyAdmin(rw http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, EnvoyAdminPath)
+	if s.sources.EnvoyAdmin == nil || !isEnvoyAdminPath(path) {
+		http.NotFound(rw, r)
+		return
+	}
+	body, err := s.sources.EnvoyAdmin("/" + path)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadGateway)
+		return
+	}
+	rw.Write(RedactPrivateKeys(body))
+}
+
+func isEnvoyAdminPath(path string) bool {
+	for _, p := range EnvoyAdminPaths {
+		if p == path {
+			return true
+		}
+	}
+	return false
+}
+
+// RedactPrivateKeys replaces pem encoded private keys in data
+func RedactPrivateKeys(data []byte) []byte {
+	return privateKeyPattern.ReplaceAll(data, []byte(redacted))
+}
+
+func writeJSON(rw http.ResponseWriter, v interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(rw)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
@@ -0,0 +1,67 @@
+package debug
+
+import (
+	"bytes"
+	"sync"
+)
+
+// LogBuffer keeps the last lines written to it, to add the recent logs of
+// the bridge and envoy to support bundles
+type LogBuffer struct {
+	lock    sync.Mutex
+	lines   [][]byte
+	next    int
+	full    bool
+	partial []byte
+}
+
+func NewLogBuffer(lines int) *LogBuffer {
+	return &LogBuffer{
+		lines: make([][]byte, lines),
+	}
+}
+
+func (b *LogBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	data := append(b.partial, p...)
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			break
+		}
+		b.add(data[:i+1])
+		data = data[i+1:]
+	}
+	b.partial = append([]byte(nil), data...)
+	return len(p), nil
+}
+
+// add stores a copy of line; the caller holds the lock
+func (b *LogBuffer) add(line []byte) {
+	if len(b.lines) == 0 {
+		return
+	}
+	b.lines[b.next] = append([]byte(nil), line...)
+	b.next = (b.next + 1) % len(b.lines)
+	if b.next == 0 {
+		b.full = true
+	}
+}
+
+// Bytes returns the kept lines, oldest first
+func (b *LogBuffer) Bytes() []byte {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	var buf bytes.Buffer
+	if b.full {
+		for _, line := range b.lines[b.next:] {
+			buf.Write(line)
+		}
+	}
+	for _, line := range b.lines[:b.next] {
+		buf.Write(line)
+	}
+	buf.Write(b.partial)
+	return buf.Bytes()
+}
@@ -3,14 +3,21 @@ package envoy
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"math/rand"
 	"net"
+	"net/http"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"runtime"
 	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -19,10 +26,18 @@ import (
 	envoybootstrap "github.com/envoyproxy/go-control-plane/envoy/config/bootstrap/v2"
 
 	"github.com/gogo/protobuf/jsonpb"
+	"github.com/solo-io/gloo-connect/pkg/backoff"
 	"github.com/solo-io/gloo/pkg/log"
 )
 
 type Config struct {
+	// port of envoy's admin api on loopback; 0 has envoy bind a free port
+	AdminPort uint32
+	// how long envoy drains removed listeners and old instances on hot restart;
+	// 0 keeps envoy's default
+	DrainTime time.Duration
+	// also gets envoy's stdout and stderr, e.g. for debug bundles; optional
+	Output io.Writer
 }
 
 type Envoy interface {
@@ -31,6 +46,14 @@ type Envoy interface {
 
 	WriteConfig(cfg Config) error
 	Reload() error
+	// SetHealthy makes envoy fail (or pass again) its health checks
+	SetHealthy(healthy bool) error
+	// ServerState returns the state reported by the admin api, e.g. LIVE
+	ServerState() (string, error)
+	// Bootstrap returns the bootstrap config written last, as json
+	Bootstrap() string
+	// Admin gets a path of the admin api, e.g. /clusters
+	Admin(path string) ([]byte, error)
 }
 
 type EnvoyInstance struct {
@@ -51,7 +74,20 @@ type envoy struct {
 	configChanged chan struct{}
 	doneInstances chan *EnvoyInstance
 
-	cfg string
+	// the debug server reads these while the bridge writes the config
+	lock sync.Mutex
+	cfg  string
+	// of the latest instance
+	adminPort uint32
+	// envoy binds a free admin port and reports it in a file
+	pickAdminPort bool
+	drainTime     time.Duration
+	output        io.Writer
+	healthy       bool
+
+	// serializes the health check calls to the admin api, so a new instance
+	// isn't failed after the bridge became healthy again
+	healthLock sync.Mutex
 }
 
 func NewEnvoy(envoyBin string, glooAddress net.Addr, id *envoycore.Node) Envoy {
@@ -63,6 +99,7 @@ func NewEnvoy(envoyBin string, glooAddress net.Addr, id *envoycore.Node) Envoy {
 		id:          id,
 		envoyBin:    envoyBin,
 		baseID:      uint32(rand.Int31()),
+		healthy:     true,
 
 		configChanged: make(chan struct{}, 10),
 		doneInstances: make(chan *EnvoyInstance),
@@ -103,9 +140,8 @@ func (e *envoy) remove(ei *EnvoyInstance) {
 }
 
 func (e *envoy) WriteConfig(cfg Config) error {
-
 	// TODO: write the envoy config file it self?
-	bootconfig, err := e.getBootstrapConfig()
+	bootconfig, err := e.getBootstrapConfig(cfg.AdminPort)
 	if err != nil {
 		return err
 	}
@@ -117,12 +153,26 @@ func (e *envoy) WriteConfig(cfg Config) error {
 		return err
 	}
 
+	e.lock.Lock()
 	e.cfg = buf.String()
+	e.pickAdminPort = cfg.AdminPort == 0
+	if !e.pickAdminPort {
+		e.adminPort = cfg.AdminPort
+	}
+	e.drainTime = cfg.DrainTime
+	e.output = cfg.Output
+	e.lock.Unlock()
 
 	return nil
 }
 
-func (e *envoy) getBootstrapConfig() (envoybootstrap.Bootstrap, error) {
+func (e *envoy) Bootstrap() string {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.cfg
+}
+
+func (e *envoy) getBootstrapConfig(adminPort uint32) (envoybootstrap.Bootstrap, error) {
 	var bootstrap envoybootstrap.Bootstrap
 
 	const glooClusterName = "xds_cluster"
@@ -175,7 +225,7 @@ func (e *envoy) getBootstrapConfig() (envoybootstrap.Bootstrap, error) {
 					Protocol: envoycore.TCP,
 					Address:  "127.0.0.1",
 					PortSpecifier: &envoycore.SocketAddress_PortValue{
-						PortValue: 0,
+						PortValue: adminPort,
 					},
 				},
 			},
@@ -211,6 +261,10 @@ func (e *envoy) getAddress() (envoyapi.Cluster_DiscoveryType, *envoycore.Address
 				},
 			},
 		}
+		// ip literals (v4 or v6) can't be resolved with dns
+		if net.ParseIP(host) != nil {
+			return envoyapi.Cluster_STATIC, tcpaddr, nil
+		}
 		return envoyapi.Cluster_STRICT_DNS, tcpaddr, nil
 	case "unix":
 		unixaddr := &envoycore.Address{
@@ -225,6 +279,88 @@ func (e *envoy) getAddress() (envoyapi.Cluster_DiscoveryType, *envoycore.Address
 	return envoyapi.Cluster_STATIC, nil, errors.New("unsupported address")
 }
 
+// readAdminPort reads the admin address envoy wrote to path
+func readAdminPort(path string) (uint32, error) {
+	address, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	_, port, err := net.SplitHostPort(strings.TrimSpace(string(address)))
+	if err != nil {
+		return 0, err
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return 0, err
+	}
+	return uint32(p), nil
+}
+
+func (e *envoy) SetHealthy(healthy bool) error {
+	e.healthLock.Lock()
+	defer e.healthLock.Unlock()
+	e.lock.Lock()
+	e.healthy = healthy
+	e.lock.Unlock()
+	return e.applyHealthy(healthy)
+}
+
+func (e *envoy) applyHealthy(healthy bool) error {
+	path := "/healthcheck/ok"
+	if !healthy {
+		path = "/healthcheck/fail"
+	}
+	resp, err := http.Post(e.adminURL(path), "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("envoy admin %v returned %v", path, resp.Status)
+	}
+	return nil
+}
+
+func (e *envoy) ServerState() (string, error) {
+	resp, err := http.Get(e.adminURL("/server_info"))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("envoy admin /server_info returned %v", resp.Status)
+	}
+	var info struct {
+		State string `json:"state"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return "", err
+	}
+	return info.State, nil
+}
+
+func (e *envoy) Admin(path string) ([]byte, error) {
+	resp, err := http.Get(e.adminURL(path))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("envoy admin %v returned %v", path, resp.Status)
+	}
+	return body, nil
+}
+
+func (e *envoy) adminURL(path string) string {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return fmt.Sprintf("http://127.0.0.1:%d%s", e.adminPort, path)
+}
+
 func (e *envoy) Reload() error {
 	e.configChanged <- struct{}{}
 	return nil
@@ -238,15 +374,59 @@ func (e *envoy) startEnvoyAndWatchit() error {
 
 	e.children = append(e.children, ei)
 
+	exited := make(chan struct{})
 	go func() {
 		// TODO: log errors
 		<-ei.Done
+		close(exited)
 		e.doneInstances <- ei
 	}()
 
+	// a new instance starts out healthy
+	e.lock.Lock()
+	healthy := e.healthy
+	e.lock.Unlock()
+	if !healthy {
+		go e.failHealthChecks(exited)
+	}
+
 	return nil
 }
 
+// failHealthChecks fails the health checks of a new instance once its admin
+// api accepts the call. It gives up when the instance exits or the bridge is
+// healthy again.
+func (e *envoy) failHealthChecks(exited <-chan struct{}) {
+	retry := backoff.Backoff{Initial: 100 * time.Millisecond, Max: 5 * time.Second}
+	for {
+		done, err := e.failIfUnhealthy()
+		if done {
+			return
+		}
+		log.Warnf("failed to fail health checks of new envoy instance, retrying: %v", err)
+		select {
+		case <-exited:
+			return
+		case <-time.After(retry.Next()):
+		}
+	}
+}
+
+func (e *envoy) failIfUnhealthy() (bool, error) {
+	e.healthLock.Lock()
+	defer e.healthLock.Unlock()
+	e.lock.Lock()
+	healthy := e.healthy
+	e.lock.Unlock()
+	if healthy {
+		return true, nil
+	}
+	if err := e.applyHealthy(false); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 func (e *envoy) Exit() {
 	for _, c := range e.children {
 		c.Process.Signal(syscall.SIGTERM)
@@ -254,10 +434,28 @@ func (e *envoy) Exit() {
 }
 
 func (e *envoy) startEnvoy() (*EnvoyInstance, error) {
+	e.lock.Lock()
+	cfg, drainTime, output, pickAdminPort := e.cfg, e.drainTime, e.output, e.pickAdminPort
+	e.lock.Unlock()
 	// start new envoy and pass the restart epoch
-	envoyCommand := exec.Command(e.envoyBin, "--restart-epoch", fmt.Sprintf("%d", e.restartEpoch), "--base-id", fmt.Sprintf("%d", e.baseID), "--config-yaml", e.cfg, "--v2-config-only")
-	envoyCommand.Stderr = os.Stderr
-	envoyCommand.Stdout = os.Stderr
+	args := []string{"--restart-epoch", fmt.Sprintf("%d", e.restartEpoch), "--base-id", fmt.Sprintf("%d", e.baseID), "--config-yaml", cfg, "--v2-config-only"}
+	if drainTime > 0 {
+		args = append(args, "--drain-time-s", fmt.Sprintf("%d", int(drainTime.Seconds())))
+	}
+	var adminAddressPath string
+	if pickAdminPort {
+		// envoy binds port 0 itself, so no other process can take the port first
+		adminAddressPath = filepath.Join(os.TempDir(), fmt.Sprintf("gloo-connect-envoy-admin-%d-%d", e.baseID, e.restartEpoch))
+		defer os.Remove(adminAddressPath)
+		args = append(args, "--admin-address-path", adminAddressPath)
+	}
+	envoyCommand := exec.Command(e.envoyBin, args...)
+	var out io.Writer = os.Stderr
+	if output != nil {
+		out = io.MultiWriter(os.Stderr, output)
+	}
+	envoyCommand.Stderr = out
+	envoyCommand.Stdout = out
 	err := envoyCommand.Start()
 	if err != nil {
 		return nil, err
@@ -286,6 +484,17 @@ func (e *envoy) startEnvoy() (*EnvoyInstance, error) {
 	case <-time.After(5 * time.Second):
 	}
 
+	if pickAdminPort {
+		port, err := readAdminPort(adminAddressPath)
+		if err != nil {
+			log.Warnf("failed to read the admin address of the new envoy instance: %v", err)
+		} else {
+			e.lock.Lock()
+			e.adminPort = port
+			e.lock.Unlock()
+		}
+	}
+
 	e.restartEpoch++
 	return &EnvoyInstance{Done: envoiddied, Process: envoyCommand.Process}, nil
 }
@@ -1,13 +1,25 @@
 package gloo
 
 import (
+	"fmt"
+	"hash/fnv"
+	"net"
+	"os"
 	"sort"
+	"strconv"
+	"sync"
+	"time"
 
+	rbac "github.com/envoyproxy/go-control-plane/envoy/config/rbac/v2alpha"
 	"github.com/gogo/protobuf/proto"
 	"github.com/pkg/errors"
 
 	"github.com/hashicorp/consul/api"
+	"github.com/solo-io/gloo-connect/pkg/authorize"
 	"github.com/solo-io/gloo-connect/pkg/consul"
+	"github.com/solo-io/gloo-connect/pkg/policy"
+	"github.com/solo-io/gloo-connect/pkg/status"
+	localstorage "github.com/solo-io/gloo-connect/pkg/storage"
 	"github.com/solo-io/gloo/pkg/api/types/v1"
 	"github.com/solo-io/gloo/pkg/log"
 	"github.com/solo-io/gloo/pkg/plugins/connect"
@@ -19,6 +31,30 @@ type ConfigWriter struct {
 	roleName   string
 	gloo       storage.Interface
 	consulInfo ConsulInfo
+	components Components
+
+	// serializes syncs; the last proxy config is kept for Resync
+	lock       sync.Mutex
+	lastConfig *api.ConnectProxyConfig
+}
+
+// Components are the parts of the bridge that the config writer keeps in
+// sync with the proxy config, besides the gloo role. All of them are optional.
+type Components struct {
+	// the bridge's local authorizer; without it envoy always asks the agent directly
+	Authorizer *authorize.Server
+	// resolves prepared queries and services in other datacenters
+	Resolver *UpstreamResolver
+	// removes the inbound listener while the target service instance is unhealthy
+	HealthGate *HealthGate
+	// limits upstream discovery to the services the proxy routes to
+	DiscoveryScope *DiscoveryScope
+	// without it, only the all-origins policies apply
+	Policies *Policies
+	// applies what the connect plugin has no settings for to the envoy config;
+	// without it, intentions are evaluated by the local authorizer
+	Plugin *Plugin
+	Status *status.Status
 }
 
 type ConsulInfo struct {
@@ -28,20 +64,83 @@ type ConsulInfo struct {
 	ConsulPort uint32
 	// path where consul is serving Authorize requests
 	AuthorizePath string
+	// hostname and port of the bridge's local authorizer
+	AuthorizerHostname string
+	AuthorizerPort     uint32
 	// dir where gloo bridge config is stored
 	ConfigDir string
 }
 
+var _ consul.ConfigWriter = &ConfigWriter{}
+
 func (cw *ConfigWriter) Write(cfg *api.ConnectProxyConfig) error {
-	return cw.syncRole(cfg)
+	cw.lock.Lock()
+	defer cw.lock.Unlock()
+	cw.lastConfig = cfg
+	return cw.sync(cfg)
+}
+
+// Resync applies the last proxy config again, e.g. after the health of the
+// target service changed
+func (cw *ConfigWriter) Resync() error {
+	cw.lock.Lock()
+	defer cw.lock.Unlock()
+	if cw.lastConfig == nil {
+		return nil
+	}
+	return cw.sync(cw.lastConfig)
+}
+
+// Remove deletes the role, and the upstreams created for it,
+// e.g. after the proxy was deregistered
+func (cw *ConfigWriter) Remove() error {
+	cw.lock.Lock()
+	defer cw.lock.Unlock()
+	cw.lastConfig = nil
+	if cw.components.Resolver != nil {
+		cw.components.Resolver.Sync(nil)
+	}
+	if cw.components.DiscoveryScope != nil {
+		cw.components.DiscoveryScope.Sync(nil, nil)
+	}
+	if _, err := cw.gloo.V1().Roles().Get(cw.roleName); err != nil {
+		// nothing was synced yet
+		return nil
+	}
+	if err := cw.gloo.V1().Roles().Delete(cw.roleName); err != nil {
+		return errors.Wrapf(err, "deleting role %v", cw.roleName)
+	}
+	return nil
 }
 
-func NewConfigWriter(gloo storage.Interface, cfg consul.ConsulConnectConfig, consulInfo ConsulInfo) (string, consul.ConfigWriter) {
+// sync syncs the role and records the result in the status
+func (cw *ConfigWriter) sync(cfg *api.ConnectProxyConfig) error {
+	err := cw.syncRole(cfg)
+	role := &status.Role{
+		Synced:   err == nil,
+		LastSync: time.Now(),
+	}
+	if err != nil {
+		role.Error = err.Error()
+		role.ConsecutiveFailures = 1
+		if previous := cw.components.Status.Get().Role; previous != nil {
+			role.ConsecutiveFailures += previous.ConsecutiveFailures
+		}
+	}
+	cw.components.Status.SetRole(role)
+	return err
+}
+
+func NewConfigWriter(gloo storage.Interface, cfg consul.ConsulConnectConfig, consulInfo ConsulInfo, components Components) (string, *ConfigWriter) {
 	roleName := cfg.ProxyId()
+	if components.Status == nil {
+		components.Status = status.New()
+	}
 	return roleName, &ConfigWriter{
 		roleName:   roleName,
 		gloo:       gloo,
 		consulInfo: consulInfo,
+		components: components,
 	}
 }
 
@@ -62,49 +161,380 @@ func (cw *ConfigWriter) syncRole(cfg *api.ConnectProxyConfig) error {
 	log.Printf("retrieved existing role %v", role)
 
 	// clone the role, use this to determine if a storage write is necessary
-	updatedRole, err := cw.updateRole(proto.Clone(role).(*v1.Role), cfg)
+	updatedRole, pending, err := cw.updateRole(proto.Clone(role).(*v1.Role), cfg)
 	if err != nil {
 		log.Warnf("error updating role: %v", err)
 		return err
 	}
+	// envoy can't bind a socket path that is left over from a previous run
+	if err := cw.clearStaleSockets(pending.outbound); err != nil {
+		log.Warnf("error updating role: %v", err)
+		return err
+	}
+	// gloo translates the role with the plugin's specs, so they go first
+	previous := cw.setListenerSpecs(pending.listenerSpecs)
 	if role.Equal(updatedRole) {
 		log.Printf("role is up to date; nothing to update")
+		cw.applyAuthorizer(pending.auth)
 		return nil
 	}
 	if _, err := cw.gloo.V1().Roles().Update(updatedRole); err != nil {
+		cw.setListenerSpecs(previous)
 		err = errors.Wrapf(err, "updating role %v", role.Name)
 		log.Warnf("error updating role: %v", err)
 		return err
 	}
+	// envoy only sends authorize requests where the role says once it is written
+	cw.applyAuthorizer(pending.auth)
 	return nil
 }
 
-func (cw *ConfigWriter) updateRole(role *v1.Role, pcfg *api.ConnectProxyConfig) (*v1.Role, error) {
+// roleSync is what updateRole leaves to be applied around the role write
+type roleSync struct {
+	auth authorizeTarget
+	listenerSpecs
+}
+
+// listenerSpecs are the plugin's specs for the role's listeners
+type listenerSpecs struct {
+	inbound map[string]InboundSpec
+	// socket paths, by listener name
+	outbound map[string]string
+}
+
+func (cw *ConfigWriter) updateRole(role *v1.Role, pcfg *api.ConnectProxyConfig) (*v1.Role, roleSync, error) {
 	cfg, err := consul.GetProxyConfig(pcfg)
 	if err != nil {
-		return nil, err
+		return nil, roleSync{}, err
 	}
 	upstreams := cfg.Upstreams
-	// one extra for the inbound listener
-	// TODO(ilackarms): support client-only services (no listener)
-	requiredListeners := 1 + len(upstreams)
-	if len(role.Listeners) < requiredListeners {
-		for i := len(role.Listeners); i <= requiredListeners; i++ {
-			role.Listeners = append(role.Listeners, &v1.Listener{})
+	// client-only services (e.g. batch jobs) have no inbound listener,
+	// and unhealthy services lose theirs until they recover,
+	// so the outbound listeners start at index 0
+	inbound := !cfg.IsClientOnly() && cw.syncHealthGate(pcfg)
+	var outboundOffset int
+	if inbound {
+		outboundOffset = 1
+	}
+	requiredListeners := outboundOffset + len(upstreams)
+	for len(role.Listeners) < requiredListeners {
+		role.Listeners = append(role.Listeners, &v1.Listener{})
+	}
+	// drop listeners for upstreams that were removed
+	role.Listeners = role.Listeners[:requiredListeners]
+	outboundPaths := make(map[string]string)
+	inboundSpecs := make(map[string]InboundSpec)
+	var auth authorizeTarget
+	if cfg.IsClientOnly() {
+		auth.settings = authorize.Settings{Mode: authorize.ModeAgent, FailurePolicy: authorize.FailClosed}
+	} else {
+		auth, err = cw.authorizeTarget(pcfg.TargetServiceName, cfg)
+		if err != nil {
+			return nil, roleSync{}, err
+		}
+		// the connect plugin's local service cluster only takes host:port,
+		// so the plugin connects envoy to the socket with a pipe address
+		if path, ok := consul.UnixSocketPath(cfg.LocalServiceAddress); ok {
+			if err := checkSocket(path); err != nil {
+				return nil, roleSync{}, errors.Wrapf(err, "local service address %v", cfg.LocalServiceAddress)
+			}
+			if cw.components.Plugin == nil {
+				return nil, roleSync{}, errors.Errorf("unix socket local service addresses require the bridge's gloo plugin")
+			}
 		}
 	}
-	syncInboundListener(role.Listeners[0], pcfg, cfg, cw.consulInfo)
 	// sort upstreams for idempotency
 	sort.SliceStable(upstreams, func(i, j int) bool {
 		return upstreams[i].LocalBindPort < upstreams[j].LocalBindPort
 	})
-	for i, upstream := range cfg.Upstreams {
-		syncOutboundListener(role.Listeners[i+1], pcfg.TargetServiceName, upstream)
+	for i, upstream := range upstreams {
+		if upstream.NeedsResolution() && cw.components.Resolver == nil {
+			return nil, roleSync{}, errors.Errorf("upstream %v needs to be resolved, but the bridge has no upstream resolver", upstream.Name())
+		}
+		listener := role.Listeners[i+outboundOffset]
+		syncOutboundListener(listener, pcfg.TargetServiceName, upstream, cw.outboundPolicy(pcfg.TargetServiceName, upstream.DestinationName))
+		// gloo only binds host:port, so the plugin has envoy listen on the socket
+		if path, ok := consul.UnixSocketPath(upstream.BindAddress()); ok {
+			if cw.components.Plugin == nil {
+				return nil, roleSync{}, errors.Errorf("unix socket bind addresses require the bridge's gloo plugin")
+			}
+			// the path lives in the plugin, so the label makes gloo translate the role again
+			listener.Labels["bind_pipe"] = path
+			outboundPaths[listener.Name] = path
+		}
+	}
+	if inbound {
+		listener := role.Listeners[0]
+		spec := InboundSpec{LocalServiceAddress: cfg.LocalServiceAddress}
+		localServiceAddress := cfg.LocalServiceAddress
+		pipe, isPipe := consul.UnixSocketPath(cfg.LocalServiceAddress)
+		if isPipe {
+			// the connect plugin's cluster is left unused behind the plugin's
+			localServiceAddress = unusedLocalServiceAddress
+		}
+		syncInboundListener(listener, pcfg, cfg, localServiceAddress, auth)
+		if isPipe {
+			// the address lives in the plugin, so the label makes gloo translate the role again
+			listener.Labels["local_service_pipe"] = pipe
+		}
+		if rules, version := cw.rbacRules(auth); rules != nil {
+			spec.RBAC = rules
+			// likewise for the rules
+			listener.Labels["rbac"] = version
+			auth.status.RBAC = true
+		}
+		if spec.replacesFilters() {
+			inboundSpecs[listener.Name] = spec
+		}
+	}
+	if cw.components.Resolver != nil {
+		cw.components.Resolver.Sync(upstreams)
+	}
+	cw.syncUpstreamOverrides(upstreams)
+	if cw.components.DiscoveryScope != nil {
+		cw.components.DiscoveryScope.Sync(role.Listeners, upstreams)
+	}
+	return role, roleSync{auth: auth, listenerSpecs: listenerSpecs{inbound: inboundSpecs, outbound: outboundPaths}}, nil
+}
+
+// syncHealthGate returns false if the target service instance is unhealthy
+func (cw *ConfigWriter) syncHealthGate(pcfg *api.ConnectProxyConfig) bool {
+	gate := cw.components.HealthGate
+	if gate == nil {
+		cw.components.Status.SetServiceHealth(nil)
+		return true
+	}
+	gate.Sync(pcfg.TargetServiceName, pcfg.TargetServiceID)
+	healthy := gate.Healthy()
+	cw.components.Status.SetServiceHealth(&status.ServiceHealth{
+		ServiceID: pcfg.TargetServiceID,
+		Healthy:   healthy,
+	})
+	if !healthy {
+		log.Warnf("service instance %v is unhealthy; removing the inbound listener", pcfg.TargetServiceID)
 	}
-	return role, nil
+	return healthy
 }
 
-func syncInboundListener(listener *v1.Listener, pcfg *api.ConnectProxyConfig, cfg *consul.ProxyConfig, consulInfo ConsulInfo) {
+// clearStaleSockets removes the sockets at the outbound listeners' new paths
+// that nothing listens on anymore. It refuses paths another process serves.
+func (cw *ConfigWriter) clearStaleSockets(paths map[string]string) error {
+	current := make(map[string]bool)
+	if cw.components.Plugin != nil {
+		// envoy listens on these already
+		for _, path := range cw.components.Plugin.Outbound() {
+			current[path] = true
+		}
+	}
+	for _, path := range paths {
+		if current[path] {
+			continue
+		}
+		if err := clearStaleSocket(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func clearStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return errors.Errorf("%v exists and is not a unix socket", path)
+	}
+	conn, err := net.DialTimeout("unix", path, staleSocketDialTimeout)
+	if err == nil {
+		conn.Close()
+		return errors.Errorf("%v is in use by another process", path)
+	}
+	// nothing accepted the connection, so the socket is left over
+	log.Printf("removing stale socket %v", path)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "removing stale socket %v", path)
+	}
+	return nil
+}
+
+const staleSocketDialTimeout = time.Second
+
+func checkSocket(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return errors.Errorf("%v is not a unix socket", path)
+	}
+	return nil
+}
+
+// upstreamOverrider is implemented by stores that can apply per-proxy settings to upstreams
+type upstreamOverrider interface {
+	SetUpstreamOverrides(overrides map[string]localstorage.UpstreamOverride)
+}
+
+// syncUpstreamOverrides applies the settings from the upstreams' config blocks
+// to the gloo upstreams they route to, and to their clusters
+func (cw *ConfigWriter) syncUpstreamOverrides(upstreams []consul.Upstream) {
+	cw.syncClusters(upstreams)
+	overrider, ok := cw.gloo.(upstreamOverrider)
+	if !ok {
+		return
+	}
+	overrides := make(map[string]localstorage.UpstreamOverride)
+	for _, upstream := range upstreams {
+		connectTimeout := upstream.Config.ConnectTimeout()
+		if connectTimeout == 0 {
+			continue
+		}
+		overrides[pconsul.UpstreamNameForConnectService(upstream.Name())] = func(us *v1.Upstream) {
+			us.ConnectionTimeout = connectTimeout
+		}
+	}
+	overrider.SetUpstreamOverrides(overrides)
+}
+
+// syncClusters hands the circuit breaker limits and the protocol of the
+// upstreams to the plugin, which sets them on the clusters
+func (cw *ConfigWriter) syncClusters(upstreams []consul.Upstream) {
+	specs := make(map[string]ClusterSpec)
+	for _, upstream := range upstreams {
+		spec := ClusterSpec{
+			Limits: upstream.Config.Limits,
+			HTTP2:  upstream.Config.Protocol == consul.ProtocolHTTP2 || upstream.Config.Protocol == consul.ProtocolGRPC,
+		}
+		if spec.isEmpty() {
+			continue
+		}
+		if cw.components.Plugin == nil {
+			log.Warnf("upstream %v: circuit breaker limits and protocol need the bridge's gloo plugin; ignoring", upstream.Name())
+			continue
+		}
+		specs[pconsul.UpstreamNameForConnectService(upstream.Name())] = spec
+	}
+	if cw.components.Plugin != nil {
+		cw.components.Plugin.SetClusters(specs)
+	}
+}
+
+// the connect plugin needs a host:port for the local service, even when the
+// plugin's pipe cluster is what envoy connects to
+const unusedLocalServiceAddress = "127.0.0.1:1"
+
+// envoy waits a little longer than the local authorizer,
+// so the authorizer's failure policy gets to decide
+const authorizerTimeoutSlack = 250 * time.Millisecond
+
+// authorizeTarget is where the inbound listener sends authorize requests
+type authorizeTarget struct {
+	hostname       string
+	port           uint32
+	path           string
+	requestTimeout time.Duration
+
+	// applied to the local authorizer once the role is written
+	settings authorize.Settings
+	// nil for client-only proxies
+	status *status.Authorize
+}
+
+func (cw *ConfigWriter) authorizeTarget(targetServiceName string, cfg *consul.ProxyConfig) (authorizeTarget, error) {
+	mode, err := authorize.ParseMode(cfg.AuthorizeMode)
+	if err != nil {
+		return authorizeTarget{}, err
+	}
+	failurePolicy, err := authorize.ParseFailurePolicy(cfg.AuthorizeFailurePolicy)
+	if err != nil {
+		return authorizeTarget{}, err
+	}
+	settings := authorize.Settings{
+		Target:        targetServiceName,
+		Mode:          mode,
+		Timeout:       cfg.AuthorizeTimeout(),
+		FailurePolicy: failurePolicy,
+	}
+	target := authorizeTarget{
+		hostname:       cw.consulInfo.ConsulHostname,
+		port:           cw.consulInfo.ConsulPort,
+		path:           cw.consulInfo.AuthorizePath,
+		requestTimeout: settings.Timeout,
+		settings:       settings,
+	}
+	// envoy can't fail open, evaluate intentions or audit decisions by itself,
+	// so route through the local authorizer
+	audited := cw.components.Authorizer != nil && cw.components.Authorizer.AuditLog() != nil
+	useAuthorizer := settings.FailurePolicy == authorize.FailOpen || settings.Mode == authorize.ModeIntentions || audited
+	if useAuthorizer && cw.components.Authorizer == nil {
+		return authorizeTarget{}, errors.Errorf("authorize mode %v with failure policy %v requires the local authorizer",
+			settings.Mode, settings.FailurePolicy)
+	}
+	if useAuthorizer {
+		target.hostname = cw.consulInfo.AuthorizerHostname
+		target.port = cw.consulInfo.AuthorizerPort
+		target.path = authorize.Path
+		target.requestTimeout = settings.Timeout + authorizerTimeoutSlack
+	}
+	target.status = &status.Authorize{
+		Address:       net.JoinHostPort(target.hostname, strconv.Itoa(int(target.port))),
+		Mode:          string(settings.Mode),
+		Timeout:       settings.Timeout.String(),
+		FailurePolicy: string(settings.FailurePolicy),
+		Audited:       audited,
+	}
+	return target, nil
+}
+
+// rbacRules renders the target's intentions for envoy's rbac filter. It
+// returns nil while the intentions are not synced, and when every decision
+// has to go through the local authorizer to be audited.
+func (cw *ConfigWriter) rbacRules(auth authorizeTarget) (*rbac.RBAC, string) {
+	if cw.components.Plugin == nil || auth.settings.Mode != authorize.ModeIntentions || auth.status.Audited {
+		return nil, ""
+	}
+	intentions, defaultAllow, ok := cw.components.Authorizer.Intentions(auth.settings.Target)
+	if !ok {
+		return nil, ""
+	}
+	return authorize.RBAC(intentions, defaultAllow), intentionsVersion(intentions, defaultAllow)
+}
+
+// intentionsVersion changes whenever the rules rendered from the intentions do
+func intentionsVersion(intentions []*api.Intention, defaultAllow bool) string {
+	h := fnv.New32a()
+	for _, ixn := range intentions {
+		fmt.Fprintf(h, "%v/%v/%v/%v;", ixn.ID, ixn.SourceNS, ixn.SourceName, ixn.Action)
+	}
+	fmt.Fprintf(h, "default=%v", defaultAllow)
+	return strconv.FormatUint(uint64(h.Sum32()), 16)
+}
+
+// setListenerSpecs returns the specs it replaced
+func (cw *ConfigWriter) setListenerSpecs(specs listenerSpecs) listenerSpecs {
+	plugin := cw.components.Plugin
+	if plugin == nil {
+		return listenerSpecs{}
+	}
+	previous := listenerSpecs{inbound: plugin.Inbound(), outbound: plugin.Outbound()}
+	plugin.SetInbound(specs.inbound)
+	plugin.SetOutbound(specs.outbound)
+	return previous
+}
+
+func (cw *ConfigWriter) applyAuthorizer(target authorizeTarget) {
+	if cw.components.Authorizer != nil {
+		// always update the authorizer, so it stops watching intentions when they are no longer used
+		cw.components.Authorizer.SetSettings(target.settings)
+	}
+	cw.components.Status.SetAuthorize(target.status)
+}
+
+func syncInboundListener(listener *v1.Listener, pcfg *api.ConnectProxyConfig, cfg *consul.ProxyConfig, localServiceAddress string, auth authorizeTarget) {
 	listener.Name = pcfg.ProxyServiceID + "-inbound"
 	listener.BindAddress = cfg.BindAddress
 	listener.BindPort = uint32(cfg.BindPort)
@@ -128,16 +558,16 @@ func syncInboundListener(listener *v1.Listener, pcfg *api.ConnectProxyConfig, cf
 		inbound = &connect.InboundListenerConfig{}
 	}
 	inbound.LocalServiceName = pcfg.TargetServiceName
-	inbound.LocalServiceAddress = cfg.LocalServiceAddress
+	inbound.LocalServiceAddress = localServiceAddress
 	authConfig := inbound.AuthConfig
 	if authConfig == nil {
 		authConfig = &connect.AuthConfig{}
 	}
 	authConfig.Target = pcfg.TargetServiceName
-	authConfig.AuthorizeHostname = consulInfo.ConsulHostname
-	authConfig.AuthorizePort = consulInfo.ConsulPort
-	authConfig.AuthorizePath = consulInfo.AuthorizePath
-	// TODO (ilackarms): RequestTimeout:
+	authConfig.AuthorizeHostname = auth.hostname
+	authConfig.AuthorizePort = auth.port
+	authConfig.AuthorizePath = auth.path
+	authConfig.RequestTimeout = &auth.requestTimeout
 	inbound.AuthConfig = authConfig
 	inboundConfig.Inbound = inbound
 	listenerConfig.Config = inboundConfig
@@ -149,14 +579,30 @@ func syncInboundListener(listener *v1.Listener, pcfg *api.ConnectProxyConfig, cf
 	}
 }
 
-func syncOutboundListener(listener *v1.Listener, targetServiceName string, upstream consul.Upstream) {
-	listener.Name = upstream.DestinationName + "-outbound"
-	// TODO (ilackarms): support ipv6
-	listener.BindAddress = "127.0.0.1"
+// outboundPolicy returns which policy applies to the outbound listener for destination
+func (cw *ConfigWriter) outboundPolicy(targetServiceName, destination string) string {
+	if cw.components.Policies != nil && cw.components.Policies.HasOriginPolicy(targetServiceName, destination) {
+		return policy.Origin
+	}
+	return policy.AllOrigins
+}
+
+func syncOutboundListener(listener *v1.Listener, targetServiceName string, upstream consul.Upstream, outboundPolicy string) {
+	listener.Name = upstream.Name() + "-outbound"
+	listener.BindAddress = upstream.BindAddress()
+	if _, ok := consul.UnixSocketPath(listener.BindAddress); ok {
+		// a placeholder, envoy listens on the socket through the plugin
+		listener.BindAddress = consul.DefaultLocalBindAddress
+	}
 	listener.BindPort = upstream.LocalBindPort
 	listener.Labels = map[string]string{
-		"service":     targetServiceName,
-		"destination": upstream.DestinationName,
+		policy.LabelService:     targetServiceName,
+		policy.LabelDestination: upstream.DestinationName,
+		policy.LabelPolicy:      outboundPolicy,
+	}
+	// lets policies select listeners by protocol
+	if upstream.Config.Protocol != "" {
+		listener.Labels["protocol"] = upstream.Config.Protocol
 	}
 	listenerConfig, err := connect.DecodeListenerConfig(listener.Config)
 	if err != nil || listenerConfig == nil {
@@ -173,9 +619,12 @@ func syncOutboundListener(listener *v1.Listener, targetServiceName string, upstr
 	if outbound == nil {
 		outbound = &connect.OutboundListenerConfig{}
 	}
-	outbound.DestinationConsulService = upstream.DestinationName
+	// upstreams resolved by the bridge are routed to the upstream it maintains for them
+	outbound.DestinationConsulService = upstream.Name()
 	outbound.DestinationConsulType = upstream.DestinationType
 	outboundConfig.Outbound = outbound
 	listenerConfig.Config = outboundConfig
 	connect.SetListenerConfig(listener, listenerConfig)
+	// the listener may have been an inbound listener before the proxy became client-only
+	listener.SslConfig = nil
 }
@@ -0,0 +1,529 @@
+package gloo_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/solo-io/gloo-connect/pkg/authorize"
+	"github.com/solo-io/gloo-connect/pkg/consul"
+	. "github.com/solo-io/gloo-connect/pkg/gloo"
+	"github.com/solo-io/gloo-connect/pkg/policy"
+	"github.com/solo-io/gloo-connect/pkg/status"
+	"github.com/solo-io/gloo-connect/pkg/storage"
+	"github.com/solo-io/gloo/pkg/api/types/v1"
+	"github.com/solo-io/gloo/pkg/plugins/connect"
+	pconsul "github.com/solo-io/gloo/pkg/plugins/consul"
+	"github.com/solo-io/gloo/pkg/storage/file"
+)
+
+type fakeConsulConnectConfig struct {
+}
+
+func (f *fakeConsulConnectConfig) ProxyId() string {
+	return "web-proxy"
+}
+
+func (f *fakeConsulConnectConfig) Token() string {
+	return "123"
+}
+
+type fakeAgent struct{}
+
+func (a *fakeAgent) ConnectAuthorize(auth *api.AgentAuthorizeParams) (*api.AgentAuthorize, error) {
+	return &api.AgentAuthorize{Authorized: true}, nil
+}
+
+func (a *fakeAgent) IntentionDefaultAllow() (bool, error) {
+	return false, nil
+}
+
+// fakeIntentions returns the intentions once, then blocks
+type fakeIntentions struct {
+	intentions []*api.Intention
+}
+
+func (i *fakeIntentions) IntentionMatch(args *api.IntentionMatch, q *api.QueryOptions) (map[string][]*api.Intention, *api.QueryMeta, error) {
+	if q.WaitIndex > 0 {
+		<-q.Context().Done()
+		return nil, nil, q.Context().Err()
+	}
+	return map[string][]*api.Intention{args.Names[0]: i.intentions}, &api.QueryMeta{LastIndex: 1}, nil
+}
+
+var _ = Describe("ConfigWriter", func() {
+	var (
+		store        *storage.PartialInMemoryConfig
+		bridgeStatus *status.Status
+		authorizer   *authorize.Server
+		roleName     string
+		writer       *ConfigWriter
+		proxyConfig  *api.ConnectProxyConfig
+	)
+
+	BeforeEach(func() {
+		store = storage.NewPartialInMemoryConfig(nil)
+		bridgeStatus = status.New()
+		authorizer = authorize.NewServer(context.Background(), nil, nil)
+		roleName, writer = NewConfigWriter(store, &fakeConsulConnectConfig{}, ConsulInfo{
+			ConsulHostname:     "127.0.0.1",
+			ConsulPort:         8500,
+			AuthorizePath:      "/v1/agent/connect/authorize",
+			AuthorizerHostname: "127.0.0.1",
+			AuthorizerPort:     9999,
+		}, Components{
+			Authorizer: authorizer,
+			Status:     bridgeStatus,
+		})
+		proxyConfig = &api.ConnectProxyConfig{
+			ProxyServiceID:    "web-proxy",
+			TargetServiceID:   "web",
+			TargetServiceName: "web",
+			Config: map[string]interface{}{
+				"bind_address":          "127.0.0.1",
+				"bind_port":             20000,
+				"local_service_address": "127.0.0.1:8080",
+				"upstreams": []interface{}{
+					map[string]interface{}{
+						"destination_name": "db",
+						"local_bind_port":  1234,
+					},
+				},
+			},
+		}
+	})
+
+	It("creates an inbound listener and an outbound listener per upstream", func() {
+		err := writer.Write(proxyConfig)
+		Expect(err).NotTo(HaveOccurred())
+
+		role, err := store.V1().Roles().Get(roleName)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(role.Listeners).To(HaveLen(2))
+		Expect(role.Listeners[0].Name).To(Equal("web-proxy-inbound"))
+		Expect(role.Listeners[0].BindPort).To(Equal(uint32(20000)))
+		Expect(role.Listeners[1].Name).To(Equal("db-outbound"))
+		Expect(role.Listeners[1].BindPort).To(Equal(uint32(1234)))
+	})
+
+	It("creates only outbound listeners for client-only proxies", func() {
+		delete(proxyConfig.Config, "bind_port")
+		delete(proxyConfig.Config, "local_service_address")
+		err := writer.Write(proxyConfig)
+		Expect(err).NotTo(HaveOccurred())
+
+		role, err := store.V1().Roles().Get(roleName)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(role.Listeners).To(HaveLen(1))
+		Expect(role.Listeners[0].Name).To(Equal("db-outbound"))
+		Expect(role.Listeners[0].SslConfig).To(BeNil())
+		listenerConfig, err := connect.DecodeListenerConfig(role.Listeners[0].Config)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(listenerConfig.GetOutbound()).NotTo(BeNil())
+	})
+
+	It("drops the inbound listener when a proxy becomes client-only", func() {
+		err := writer.Write(proxyConfig)
+		Expect(err).NotTo(HaveOccurred())
+
+		proxyConfig.Config["client_only"] = true
+		err = writer.Write(proxyConfig)
+		Expect(err).NotTo(HaveOccurred())
+
+		role, err := store.V1().Roles().Get(roleName)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(role.Listeners).To(HaveLen(1))
+		Expect(role.Listeners[0].Name).To(Equal("db-outbound"))
+	})
+
+	It("applies the upstream config block", func() {
+		_, err := store.V1().Upstreams().Create(&v1.Upstream{Name: pconsul.UpstreamNameForConnectService("db")})
+		Expect(err).NotTo(HaveOccurred())
+
+		proxyConfig.Config["upstreams"] = []interface{}{
+			map[string]interface{}{
+				"destination_name": "db",
+				"local_bind_port":  1234,
+				"config": map[string]interface{}{
+					"connect_timeout_ms": 250,
+					"protocol":           "http",
+					"local_bind_address": "0.0.0.0",
+				},
+			},
+		}
+		err = writer.Write(proxyConfig)
+		Expect(err).NotTo(HaveOccurred())
+
+		role, err := store.V1().Roles().Get(roleName)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(role.Listeners[1].BindAddress).To(Equal("0.0.0.0"))
+		Expect(role.Listeners[1].Labels).To(HaveKeyWithValue("protocol", "http"))
+		// without policies of its own, the service gets the all-origins ones
+		Expect(role.Listeners[1].Labels).To(HaveKeyWithValue("policy", "all-origins"))
+
+		us, err := store.V1().Upstreams().Get(pconsul.UpstreamNameForConnectService("db"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(us.ConnectionTimeout).To(Equal(250 * time.Millisecond))
+	})
+
+	It("hands circuit breaker limits and the protocol to the plugin", func() {
+		plugin := NewPlugin()
+		roleName, writer = NewConfigWriter(store, &fakeConsulConnectConfig{}, ConsulInfo{}, Components{
+			Authorizer: authorizer,
+			Plugin:     plugin,
+			Status:     bridgeStatus,
+		})
+		proxyConfig.Config["upstreams"] = []interface{}{
+			map[string]interface{}{
+				"destination_name": "db",
+				"local_bind_port":  1234,
+				"config": map[string]interface{}{
+					"protocol": "grpc",
+					"limits": map[string]interface{}{
+						"max_connections": 10,
+					},
+				},
+			},
+			map[string]interface{}{
+				"destination_name": "cache",
+				"local_bind_port":  1235,
+			},
+		}
+		err := writer.Write(proxyConfig)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(plugin.Clusters()).To(Equal(map[string]ClusterSpec{
+			pconsul.UpstreamNameForConnectService("db"): {
+				Limits: consul.UpstreamLimits{MaxConnections: 10},
+				HTTP2:  true,
+			},
+		}))
+	})
+
+	Context("unix sockets", func() {
+		var (
+			dir    string
+			plugin *Plugin
+		)
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "")
+			Expect(err).NotTo(HaveOccurred())
+			plugin = NewPlugin()
+			roleName, writer = NewConfigWriter(store, &fakeConsulConnectConfig{}, ConsulInfo{
+				ConsulHostname: "127.0.0.1",
+				ConsulPort:     8500,
+				AuthorizePath:  "/v1/agent/connect/authorize",
+			}, Components{Plugin: plugin})
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("has envoy connect to the socket directly", func() {
+			socket := filepath.Join(dir, "web.sock")
+			app, err := net.Listen("unix", socket)
+			Expect(err).NotTo(HaveOccurred())
+			defer app.Close()
+
+			proxyConfig.Config["local_service_address"] = "unix://" + socket
+			err = writer.Write(proxyConfig)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(plugin.Inbound()).To(Equal(map[string]InboundSpec{
+				"web-proxy-inbound": {LocalServiceAddress: "unix://" + socket},
+			}))
+			clusters, err := plugin.GeneratedClusters(nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(clusters).To(HaveLen(1))
+			Expect(clusters[0].Hosts[0].GetPipe().Path).To(Equal(socket))
+
+			role, err := store.V1().Roles().Get(roleName)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(role.Listeners[0].Labels).To(HaveKeyWithValue("local_service_pipe", socket))
+		})
+
+		It("rejects a socket that doesn't exist", func() {
+			proxyConfig.Config["local_service_address"] = "unix://" + filepath.Join(dir, "missing.sock")
+			err := writer.Write(proxyConfig)
+			Expect(err).To(HaveOccurred())
+		})
+
+		outboundSocket := func(socket string) {
+			proxyConfig.Config["upstreams"] = []interface{}{
+				map[string]interface{}{
+					"destination_name":   "db",
+					"local_bind_address": "unix://" + socket,
+				},
+			}
+		}
+
+		It("has envoy listen on an upstream's socket", func() {
+			socket := filepath.Join(dir, "db.sock")
+			outboundSocket(socket)
+			err := writer.Write(proxyConfig)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(plugin.Outbound()).To(Equal(map[string]string{"db-outbound": socket}))
+			role, err := store.V1().Roles().Get(roleName)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(role.Listeners[1].Labels).To(HaveKeyWithValue("bind_pipe", socket))
+		})
+
+		It("removes a stale socket", func() {
+			socket := filepath.Join(dir, "db.sock")
+			stale, err := net.Listen("unix", socket)
+			Expect(err).NotTo(HaveOccurred())
+			// keep the file when closing, like a process that was killed
+			stale.(*net.UnixListener).SetUnlinkOnClose(false)
+			stale.Close()
+
+			outboundSocket(socket)
+			err = writer.Write(proxyConfig)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = os.Stat(socket)
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+
+		It("refuses a socket another process listens on", func() {
+			socket := filepath.Join(dir, "db.sock")
+			other, err := net.Listen("unix", socket)
+			Expect(err).NotTo(HaveOccurred())
+			defer other.Close()
+
+			outboundSocket(socket)
+			err = writer.Write(proxyConfig)
+			Expect(err).To(HaveOccurred())
+			Expect(plugin.Outbound()).To(BeEmpty())
+			_, err = os.Stat(socket)
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
+	Context("authorization", func() {
+		getAuthConfig := func() *connect.AuthConfig {
+			role, err := store.V1().Roles().Get(roleName)
+			Expect(err).NotTo(HaveOccurred())
+			listenerConfig, err := connect.DecodeListenerConfig(role.Listeners[0].Config)
+			Expect(err).NotTo(HaveOccurred())
+			return listenerConfig.GetInbound().AuthConfig
+		}
+
+		It("asks the agent directly with the default timeout", func() {
+			err := writer.Write(proxyConfig)
+			Expect(err).NotTo(HaveOccurred())
+
+			authConfig := getAuthConfig()
+			Expect(authConfig.AuthorizePort).To(Equal(uint32(8500)))
+			Expect(*authConfig.RequestTimeout).To(Equal(time.Second))
+			Expect(bridgeStatus.Get().Authorize.FailurePolicy).To(Equal("closed"))
+		})
+
+		It("routes through the local authorizer when failing open", func() {
+			proxyConfig.Config["authorize_timeout_ms"] = 200
+			proxyConfig.Config["authorize_failure_policy"] = "open"
+			err := writer.Write(proxyConfig)
+			Expect(err).NotTo(HaveOccurred())
+
+			authConfig := getAuthConfig()
+			Expect(authConfig.AuthorizePort).To(Equal(uint32(9999)))
+			Expect(authConfig.AuthorizePath).To(Equal(authorize.Path))
+			Expect(*authConfig.RequestTimeout).To(BeNumerically(">", 200*time.Millisecond))
+			Expect(authorizer.Settings()).To(Equal(authorize.Settings{
+				Target:        "web",
+				Mode:          authorize.ModeAgent,
+				Timeout:       200 * time.Millisecond,
+				FailurePolicy: authorize.FailOpen,
+			}))
+			Expect(bridgeStatus.Get().Authorize.Address).To(Equal("127.0.0.1:9999"))
+		})
+
+		It("keeps the authorizer settings when the role can't be synced", func() {
+			proxyConfig.Config["authorize_failure_policy"] = "open"
+			err := writer.Write(proxyConfig)
+			Expect(err).NotTo(HaveOccurred())
+
+			// resolving a prepared query needs the resolver, which this writer doesn't have
+			proxyConfig.Config["authorize_failure_policy"] = "closed"
+			proxyConfig.Config["upstreams"] = []interface{}{
+				map[string]interface{}{
+					"destination_type": "prepared_query",
+					"destination_name": "db",
+					"local_bind_port":  1234,
+				},
+			}
+			err = writer.Write(proxyConfig)
+			Expect(err).To(HaveOccurred())
+			Expect(authorizer.Settings().FailurePolicy).To(Equal(authorize.FailOpen))
+			Expect(bridgeStatus.Get().Authorize.FailurePolicy).To(Equal("open"))
+		})
+
+		Context("rendering intentions", func() {
+			var (
+				ctx    context.Context
+				cancel context.CancelFunc
+				plugin *Plugin
+			)
+
+			BeforeEach(func() {
+				ctx, cancel = context.WithCancel(context.Background())
+				authorizer = authorize.NewServer(ctx, &fakeAgent{}, &fakeIntentions{
+					intentions: []*api.Intention{{
+						ID:         "db-to-web",
+						SourceNS:   "default",
+						SourceName: "db",
+						Action:     api.IntentionActionAllow,
+					}},
+				})
+				plugin = NewPlugin()
+				roleName, writer = NewConfigWriter(store, &fakeConsulConnectConfig{}, ConsulInfo{
+					AuthorizerHostname: "127.0.0.1",
+					AuthorizerPort:     9999,
+				}, Components{
+					Authorizer: authorizer,
+					Plugin:     plugin,
+					Status:     bridgeStatus,
+				})
+				proxyConfig.Config["authorize_mode"] = "intentions"
+			})
+
+			AfterEach(func() {
+				cancel()
+			})
+
+			It("gives envoy rbac rules once the intentions are synced", func() {
+				err := writer.Write(proxyConfig)
+				Expect(err).NotTo(HaveOccurred())
+				// the authorizer only starts watching once the role is written
+				Expect(plugin.Inbound()).To(BeEmpty())
+				Eventually(authorizer.IntentionChanges()).Should(Receive())
+
+				err = writer.Resync()
+				Expect(err).NotTo(HaveOccurred())
+				spec := plugin.Inbound()["web-proxy-inbound"]
+				Expect(spec.RBAC).NotTo(BeNil())
+				Expect(spec.RBAC.Policies).To(HaveKey("db-to-web"))
+				role, err := store.V1().Roles().Get(roleName)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(role.Listeners[0].Labels).To(HaveKey("rbac"))
+				Expect(bridgeStatus.Get().Authorize.RBAC).To(BeTrue())
+			})
+
+			It("leaves decisions to the local authorizer when they are audited", func() {
+				authorizer.SetAuditLog(authorize.NewAuditLog(ioutil.Discard))
+				err := writer.Write(proxyConfig)
+				Expect(err).NotTo(HaveOccurred())
+				Eventually(authorizer.IntentionChanges()).Should(Receive())
+
+				err = writer.Resync()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(plugin.Inbound()).To(BeEmpty())
+				Expect(getAuthConfig().AuthorizePort).To(Equal(uint32(9999)))
+			})
+		})
+
+		It("rejects unknown failure policies", func() {
+			proxyConfig.Config["authorize_failure_policy"] = "sometimes"
+			err := writer.Write(proxyConfig)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("policies", func() {
+		var (
+			ctx      context.Context
+			cancel   context.CancelFunc
+			dir      string
+			policies *Policies
+		)
+
+		BeforeEach(func() {
+			ctx, cancel = context.WithCancel(context.Background())
+			var err error
+			dir, err = ioutil.TempDir("", "policies")
+			Expect(err).NotTo(HaveOccurred())
+			gloo, err := file.NewStorage(dir, time.Second)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(gloo.V1().Register()).To(Succeed())
+			store = storage.NewPartialInMemoryConfig(gloo)
+		})
+
+		AfterEach(func() {
+			cancel()
+			os.RemoveAll(dir)
+		})
+
+		JustBeforeEach(func() {
+			var err error
+			policies, err = WatchPolicies(ctx, store)
+			Expect(err).NotTo(HaveOccurred())
+			roleName, writer = NewConfigWriter(store, &fakeConsulConnectConfig{}, ConsulInfo{}, Components{
+				Authorizer: authorizer,
+				Policies:   policies,
+				Status:     bridgeStatus,
+			})
+			err = writer.Write(proxyConfig)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		outboundPolicy := func() string {
+			role, err := store.V1().Roles().Get(roleName)
+			Expect(err).NotTo(HaveOccurred())
+			return role.Listeners[1].Labels[policy.LabelPolicy]
+		}
+
+		writeAttribute := func(selector map[string]string) {
+			_, err := store.V1().Attributes().Create(&v1.Attribute{
+				Name: policy.Name("web", "db"),
+				AttributeType: &v1.Attribute_ListenerAttribute{
+					ListenerAttribute: &v1.ListenerAttribute{
+						Selector:        selector,
+						VirtualServices: []string{policy.Name("web", "db")},
+					},
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		writeVirtualService := func() {
+			_, err := store.V1().VirtualServices().Create(&v1.VirtualService{Name: policy.Name("web", "db")})
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		It("switches the outbound listener to the origin policy once the service has one", func() {
+			Expect(outboundPolicy()).To(Equal(policy.AllOrigins))
+
+			writeVirtualService()
+			writeAttribute(policy.Selector("web", "db"))
+			Eventually(func() bool { return policies.HasOriginPolicy("web", "db") }, "5s").Should(BeTrue())
+
+			err := writer.Resync()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(outboundPolicy()).To(Equal(policy.Origin))
+		})
+
+		It("waits for the virtual service of the origin policy", func() {
+			writeAttribute(policy.Selector("web", "db"))
+			Consistently(func() bool { return policies.HasOriginPolicy("web", "db") }, "2s").Should(BeFalse())
+
+			writeVirtualService()
+			Eventually(func() bool { return policies.HasOriginPolicy("web", "db") }, "5s").Should(BeTrue())
+		})
+
+		It("keeps legacy origin policies from taking precedence", func() {
+			writeVirtualService()
+			writeAttribute(map[string]string{policy.LabelService: "web", policy.LabelDestination: "db"})
+			Consistently(func() bool { return policies.HasOriginPolicy("web", "db") }, "2s").Should(BeFalse())
+		})
+	})
+})
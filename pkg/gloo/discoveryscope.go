@@ -0,0 +1,216 @@
+package gloo
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/solo-io/gloo-connect/pkg/consul"
+	"github.com/solo-io/gloo/pkg/api/types/v1"
+	"github.com/solo-io/gloo/pkg/log"
+	pconsul "github.com/solo-io/gloo/pkg/plugins/consul"
+	"github.com/solo-io/gloo/pkg/storage"
+)
+
+// scopeSetter is implemented by the store upstream discovery writes to
+type scopeSetter interface {
+	SetScope(names []string)
+}
+
+// DiscoveryScope limits upstream discovery to the services the proxy routes
+// to: its upstreams, and the destinations of the policies that select its
+// listeners. The scope is updated when the proxy config or the policies change.
+type DiscoveryScope struct {
+	gloo   storage.Interface
+	scoped scopeSetter
+
+	lock      sync.Mutex
+	listeners []*v1.Listener
+	upstreams []string
+	scope     []string
+}
+
+// policies are watched until ctx is cancelled
+func NewDiscoveryScope(ctx context.Context, gloo storage.Interface, scoped scopeSetter) (*DiscoveryScope, error) {
+	s := &DiscoveryScope{
+		gloo:   gloo,
+		scoped: scoped,
+	}
+	attributes, err := gloo.V1().Attributes().Watch(&attributeEventHandler{scope: s})
+	if err != nil {
+		return nil, err
+	}
+	virtualServices, err := gloo.V1().VirtualServices().Watch(&virtualServiceEventHandler{scope: s})
+	if err != nil {
+		return nil, err
+	}
+	errs := make(chan error)
+	go attributes.Run(ctx.Done(), errs)
+	go virtualServices.Run(ctx.Done(), errs)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case err := <-errs:
+				log.Warnf("error watching policies for the discovery scope: %v", err)
+			}
+		}
+	}()
+	return s, nil
+}
+
+// Sync sets the listeners of the role and the proxy's upstreams
+func (s *DiscoveryScope) Sync(listeners []*v1.Listener, upstreams []consul.Upstream) {
+	var names []string
+	for _, upstream := range upstreams {
+		// the resolver maintains these upstreams itself
+		if upstream.NeedsResolution() {
+			continue
+		}
+		names = append(names, pconsul.UpstreamNameForConnectService(upstream.Name()))
+	}
+	s.lock.Lock()
+	s.listeners = listeners
+	s.upstreams = names
+	s.lock.Unlock()
+	s.update()
+}
+
+// update recomputes the scope and applies it if it changed
+func (s *DiscoveryScope) update() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	names := make(map[string]bool)
+	for _, name := range s.upstreams {
+		names[name] = true
+	}
+	destinations, err := s.policyDestinations()
+	if err != nil {
+		// keep the destinations that are in scope already
+		log.Warnf("failed to read the policies for the discovery scope: %v", err)
+		for _, name := range s.scope {
+			names[name] = true
+		}
+	}
+	for _, name := range destinations {
+		names[name] = true
+	}
+	var scope []string
+	for name := range names {
+		scope = append(scope, name)
+	}
+	sort.Strings(scope)
+	if s.scope != nil && equalStrings(scope, s.scope) {
+		return
+	}
+	log.Printf("discovering upstreams %v", scope)
+	s.scope = scope
+	s.scoped.SetScope(scope)
+}
+
+// policyDestinations returns the upstreams routed to by the virtual services
+// of the attributes that select one of the listeners; the caller holds the lock
+func (s *DiscoveryScope) policyDestinations() ([]string, error) {
+	if len(s.listeners) == 0 {
+		return nil, nil
+	}
+	attributes, err := s.gloo.V1().Attributes().List()
+	if err != nil {
+		return nil, err
+	}
+	var destinations []string
+	for _, attribute := range attributes {
+		listenerAttribute := attribute.GetListenerAttribute()
+		if listenerAttribute == nil || !s.selectsListener(listenerAttribute.Selector) {
+			continue
+		}
+		for _, name := range listenerAttribute.VirtualServices {
+			vs, err := s.gloo.V1().VirtualServices().Get(name)
+			if err != nil {
+				// the attribute may be written before its virtual service
+				continue
+			}
+			for _, route := range vs.Routes {
+				destinations = append(destinations, routeDestinations(route)...)
+			}
+		}
+	}
+	return destinations, nil
+}
+
+func (s *DiscoveryScope) selectsListener(selector map[string]string) bool {
+	for _, listener := range s.listeners {
+		if matchesLabels(selector, listener.Labels) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesLabels(selector, labels map[string]string) bool {
+	for k, v := range selector {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func routeDestinations(route *v1.Route) []string {
+	var destinations []string
+	add := func(dest *v1.Destination) {
+		if dest == nil {
+			return
+		}
+		if us := dest.GetUpstream(); us != nil {
+			destinations = append(destinations, us.Name)
+		}
+		if fn := dest.GetFunction(); fn != nil {
+			destinations = append(destinations, fn.UpstreamName)
+		}
+	}
+	add(route.SingleDestination)
+	for _, weighted := range route.MultipleDestinations {
+		add(weighted.Destination)
+	}
+	return destinations
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// attributeEventHandler updates the scope on every change to the attributes
+type attributeEventHandler struct {
+	scope *DiscoveryScope
+}
+
+func (h *attributeEventHandler) OnAdd(_ []*v1.Attribute, _ *v1.Attribute)    { h.scope.update() }
+func (h *attributeEventHandler) OnUpdate(_ []*v1.Attribute, _ *v1.Attribute) { h.scope.update() }
+func (h *attributeEventHandler) OnDelete(_ []*v1.Attribute, _ *v1.Attribute) { h.scope.update() }
+
+// virtualServiceEventHandler updates the scope on every change to the virtual services
+type virtualServiceEventHandler struct {
+	scope *DiscoveryScope
+}
+
+func (h *virtualServiceEventHandler) OnAdd(_ []*v1.VirtualService, _ *v1.VirtualService) {
+	h.scope.update()
+}
+
+func (h *virtualServiceEventHandler) OnUpdate(_ []*v1.VirtualService, _ *v1.VirtualService) {
+	h.scope.update()
+}
+
+func (h *virtualServiceEventHandler) OnDelete(_ []*v1.VirtualService, _ *v1.VirtualService) {
+	h.scope.update()
+}
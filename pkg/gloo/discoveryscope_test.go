@@ -0,0 +1,133 @@
+package gloo_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/solo-io/gloo-connect/pkg/consul"
+	. "github.com/solo-io/gloo-connect/pkg/gloo"
+	"github.com/solo-io/gloo-connect/pkg/storage"
+	"github.com/solo-io/gloo/pkg/api/types/v1"
+	pconsul "github.com/solo-io/gloo/pkg/plugins/consul"
+	"github.com/solo-io/gloo/pkg/storage/file"
+)
+
+type fakeScopeSetter struct {
+	lock  sync.Mutex
+	scope []string
+}
+
+func (f *fakeScopeSetter) SetScope(names []string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.scope = names
+}
+
+func (f *fakeScopeSetter) Scope() []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.scope
+}
+
+var _ = Describe("DiscoveryScope", func() {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+		dir    string
+		store  *storage.PartialInMemoryConfig
+		scoped *fakeScopeSetter
+		scope  *DiscoveryScope
+	)
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithCancel(context.Background())
+		var err error
+		dir, err = ioutil.TempDir("", "discoveryscope")
+		Expect(err).NotTo(HaveOccurred())
+		gloo, err := file.NewStorage(dir, time.Second)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(gloo.V1().Register()).To(Succeed())
+		store = storage.NewPartialInMemoryConfig(gloo)
+		scoped = &fakeScopeSetter{}
+		scope, err = NewDiscoveryScope(ctx, store, scoped)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		cancel()
+		os.RemoveAll(dir)
+	})
+
+	// writePolicy routes the listeners the selector matches to upstream
+	writePolicy := func(name string, selector map[string]string, upstream string) {
+		_, err := store.V1().VirtualServices().Create(&v1.VirtualService{
+			Name: name,
+			Routes: []*v1.Route{{
+				SingleDestination: &v1.Destination{
+					DestinationType: &v1.Destination_Upstream{
+						Upstream: &v1.UpstreamDestination{Name: upstream},
+					},
+				},
+			}},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = store.V1().Attributes().Create(&v1.Attribute{
+			Name: name,
+			AttributeType: &v1.Attribute_ListenerAttribute{
+				ListenerAttribute: &v1.ListenerAttribute{
+					Selector:        selector,
+					VirtualServices: []string{name},
+				},
+			},
+		})
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	listeners := []*v1.Listener{{
+		Name:   "db-outbound",
+		Labels: map[string]string{"service": "web", "destination": "db"},
+	}}
+
+	It("adds the destinations of the policies that select the proxy's listeners", func() {
+		writePolicy("web-to-db", map[string]string{"service": "web", "destination": "db"}, "db-v2")
+		writePolicy("api-to-db", map[string]string{"service": "api", "destination": "db"}, "db-v3")
+
+		scope.Sync(listeners, []consul.Upstream{{DestinationName: "db"}})
+		Expect(scoped.Scope()).To(ConsistOf("db-v2", pconsul.UpstreamNameForConnectService("db")))
+	})
+
+	It("only selects listeners that have every label of the selector", func() {
+		writePolicy("web-to-db", map[string]string{"service": "web", "destination": "db", "policy": "origin"}, "db-v2")
+
+		scope.Sync(listeners, nil)
+		Expect(scoped.Scope()).To(BeEmpty())
+	})
+
+	It("skips policies whose virtual service isn't written yet", func() {
+		_, err := store.V1().Attributes().Create(&v1.Attribute{
+			Name: "web-to-db",
+			AttributeType: &v1.Attribute_ListenerAttribute{
+				ListenerAttribute: &v1.ListenerAttribute{
+					Selector:        map[string]string{"service": "web"},
+					VirtualServices: []string{"web-to-db"},
+				},
+			},
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		scope.Sync(listeners, []consul.Upstream{{DestinationName: "db"}})
+		Expect(scoped.Scope()).To(Equal([]string{pconsul.UpstreamNameForConnectService("db")}))
+	})
+
+	It("follows policies that are added later", func() {
+		scope.Sync(listeners, nil)
+		writePolicy("web-to-db", map[string]string{"service": "web"}, "db-v2")
+		Eventually(scoped.Scope, "5s").Should(Equal([]string{"db-v2"}))
+	})
+})
@@ -0,0 +1,85 @@
+package gloo
+
+import (
+	"context"
+	"sync"
+
+	"github.com/solo-io/gloo-connect/pkg/consul"
+	"github.com/solo-io/gloo/pkg/log"
+)
+
+// HealthGate watches the consul health checks of the proxy's target service
+// instance. While the instance is unhealthy, the config writer removes the
+// inbound listener, so envoy drains it and stops accepting mesh traffic.
+type HealthGate struct {
+	ctx    context.Context
+	health consul.ServiceHealthClient
+	node   string
+
+	lock      sync.Mutex
+	healthy   bool
+	serviceID string
+	cancel    context.CancelFunc
+	changes   chan bool
+}
+
+// health is watched until ctx is cancelled. node is the name of the agent's
+// node; service ids are only unique per node.
+func NewHealthGate(ctx context.Context, health consul.ServiceHealthClient, node string) *HealthGate {
+	return &HealthGate{
+		ctx:    ctx,
+		health: health,
+		node:   node,
+		// don't drop traffic until the first result is in
+		healthy: true,
+		changes: make(chan bool, 1),
+	}
+}
+
+// Sync starts watching the target service instance, if it changed
+func (g *HealthGate) Sync(service, serviceID string) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.serviceID == serviceID {
+		return
+	}
+	if g.cancel != nil {
+		g.cancel()
+	}
+	ctx, cancel := context.WithCancel(g.ctx)
+	g.serviceID = serviceID
+	g.cancel = cancel
+	go consul.WatchServiceHealth(ctx, g.health, g.node, service, serviceID, func(healthy bool) {
+		if ctx.Err() != nil {
+			return
+		}
+		g.set(healthy)
+	})
+}
+
+func (g *HealthGate) set(healthy bool) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.healthy == healthy {
+		return
+	}
+	log.Printf("service instance %v is healthy: %v", g.serviceID, healthy)
+	g.healthy = healthy
+	// only the latest state matters
+	select {
+	case <-g.changes:
+	default:
+	}
+	g.changes <- healthy
+}
+
+func (g *HealthGate) Healthy() bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.healthy
+}
+
+// Changes receives the new state whenever the instance becomes healthy or unhealthy
+func (g *HealthGate) Changes() <-chan bool {
+	return g.changes
+}
@@ -0,0 +1,277 @@
+package gloo
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	envoyapi "github.com/envoyproxy/go-control-plane/envoy/api/v2"
+	envoycluster "github.com/envoyproxy/go-control-plane/envoy/api/v2/cluster"
+	envoycore "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	envoylistener "github.com/envoyproxy/go-control-plane/envoy/api/v2/listener"
+	rbacfilter "github.com/envoyproxy/go-control-plane/envoy/config/filter/network/rbac/v2"
+	tcpproxy "github.com/envoyproxy/go-control-plane/envoy/config/filter/network/tcp_proxy/v2"
+	rbac "github.com/envoyproxy/go-control-plane/envoy/config/rbac/v2alpha"
+	"github.com/envoyproxy/go-control-plane/pkg/util"
+	"github.com/gogo/protobuf/types"
+	"github.com/pkg/errors"
+	"github.com/solo-io/gloo-connect/pkg/consul"
+	"github.com/solo-io/gloo/pkg/api/types/v1"
+	"github.com/solo-io/gloo/pkg/plugins"
+)
+
+const rbacFilterName = "envoy.filters.network.rbac"
+
+// Plugin is the bridge's gloo translator plugin. It applies the parts of the
+// proxy config the connect plugin has no settings for to the envoy config:
+// rbac rules and a unix socket local service for the inbound listener, unix
+// socket addresses for the outbound listeners, and circuit breakers and
+// http/2 for the upstreams' clusters. The config writer
+// sets them before it writes the role or the upstream overrides, so they are
+// in place when gloo translates.
+type Plugin struct {
+	lock sync.RWMutex
+	// by listener name
+	inbound map[string]InboundSpec
+	// socket paths of the outbound listeners, by listener name
+	outbound map[string]string
+	// by upstream name
+	clusters map[string]ClusterSpec
+}
+
+// InboundSpec replaces the connect plugin's authorization or local service
+// cluster for an inbound listener
+type InboundSpec struct {
+	// rules envoy decides connections by; nil keeps the connect plugin's
+	// authorize filter
+	RBAC *rbac.RBAC
+	// the local service, as host:port or unix:///path/to/socket
+	LocalServiceAddress string
+}
+
+func (s InboundSpec) replacesFilters() bool {
+	_, pipe := consul.UnixSocketPath(s.LocalServiceAddress)
+	return s.RBAC != nil || pipe
+}
+
+// ClusterSpec holds the cluster settings from an upstream's config block
+type ClusterSpec struct {
+	Limits consul.UpstreamLimits
+	HTTP2  bool
+}
+
+func (s ClusterSpec) isEmpty() bool {
+	return s.Limits.IsEmpty() && !s.HTTP2
+}
+
+var (
+	_ plugins.ListenerPlugin         = &Plugin{}
+	_ plugins.ListenerFilterPlugin   = &Plugin{}
+	_ plugins.ClusterGeneratorPlugin = &Plugin{}
+	_ plugins.UpstreamPlugin         = &Plugin{}
+)
+
+// the plugin has to be registered with gloo before the control plane is set up
+func NewPlugin() *Plugin {
+	return &Plugin{
+		inbound:  make(map[string]InboundSpec),
+		outbound: make(map[string]string),
+		clusters: make(map[string]ClusterSpec),
+	}
+}
+
+// SetInbound sets the specs of the inbound listeners, by listener name
+func (p *Plugin) SetInbound(specs map[string]InboundSpec) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.inbound = specs
+}
+
+func (p *Plugin) Inbound() map[string]InboundSpec {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.inbound
+}
+
+// SetOutbound sets the socket paths of the outbound listeners, by listener name
+func (p *Plugin) SetOutbound(paths map[string]string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.outbound = paths
+}
+
+func (p *Plugin) Outbound() map[string]string {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.outbound
+}
+
+// SetClusters sets the specs of the upstreams' clusters, by upstream name
+func (p *Plugin) SetClusters(specs map[string]ClusterSpec) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.clusters = specs
+}
+
+func (p *Plugin) Clusters() map[string]ClusterSpec {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.clusters
+}
+
+func (p *Plugin) GetDependencies(_ *v1.Config) *plugins.DependenciesDescriptor {
+	return nil
+}
+
+// ProcessListener has envoy listen on the outbound listener's unix socket
+// instead of the placeholder address in the role
+func (p *Plugin) ProcessListener(_ *plugins.ListenerPluginParams, in *v1.Listener, out *envoyapi.Listener) error {
+	p.lock.RLock()
+	path, ok := p.outbound[in.Name]
+	p.lock.RUnlock()
+	if !ok {
+		return nil
+	}
+	out.Address = envoycore.Address{
+		Address: &envoycore.Address_Pipe{
+			Pipe: &envoycore.Pipe{Path: path},
+		},
+	}
+	return nil
+}
+
+// ProcessListenerFilters puts the rbac filter and a tcp proxy to the plugin's
+// local service cluster in front of the connect plugin's filters. A tcp proxy
+// ends the filter chain, so envoy never reaches the connect plugin's
+// authorize filter and tcp proxy behind it. Without rbac rules, the filters
+// go after the authorize filter, which still decides.
+func (p *Plugin) ProcessListenerFilters(_ *plugins.ListenerFilterPluginParams, in *v1.Listener) ([]plugins.StagedListenerFilter, error) {
+	p.lock.RLock()
+	spec, ok := p.inbound[in.Name]
+	p.lock.RUnlock()
+	if !ok || !spec.replacesFilters() {
+		return nil, nil
+	}
+	stage := plugins.PostInAuth
+	var filters []plugins.StagedListenerFilter
+	if spec.RBAC != nil {
+		stage = plugins.PreInAuth
+		config, err := util.MessageToStruct(&rbacfilter.RBAC{
+			StatPrefix: "inbound_rbac",
+			Rules:      spec.RBAC,
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "encoding rbac filter")
+		}
+		filters = append(filters, plugins.StagedListenerFilter{
+			ListenerFilter: envoylistener.Filter{Name: rbacFilterName, Config: config},
+			Stage:          stage,
+		})
+	}
+	config, err := util.MessageToStruct(&tcpproxy.TcpProxy{
+		StatPrefix:       "inbound_local_service",
+		ClusterSpecifier: &tcpproxy.TcpProxy_Cluster{Cluster: localServiceCluster(in.Name)},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "encoding tcp proxy filter")
+	}
+	filters = append(filters, plugins.StagedListenerFilter{
+		ListenerFilter: envoylistener.Filter{Name: util.TCPProxy, Config: config},
+		Stage:          stage,
+	})
+	return filters, nil
+}
+
+// GeneratedClusters returns the local service clusters of the inbound
+// listeners whose filters the plugin replaces
+func (p *Plugin) GeneratedClusters(_ *plugins.ClusterGeneratorPluginParams) ([]*envoyapi.Cluster, error) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	var clusters []*envoyapi.Cluster
+	for name, spec := range p.inbound {
+		if !spec.replacesFilters() {
+			continue
+		}
+		address, err := localServiceAddress(spec.LocalServiceAddress)
+		if err != nil {
+			return nil, errors.Wrapf(err, "listener %v", name)
+		}
+		clusters = append(clusters, &envoyapi.Cluster{
+			Name:           localServiceCluster(name),
+			ConnectTimeout: localServiceConnectTimeout,
+			Type:           envoyapi.Cluster_STATIC,
+			Hosts:          []*envoycore.Address{address},
+		})
+	}
+	return clusters, nil
+}
+
+// ProcessUpstream applies the circuit breakers and protocol from the
+// upstream's config block
+func (p *Plugin) ProcessUpstream(_ *plugins.UpstreamPluginParams, in *v1.Upstream, out *envoyapi.Cluster) error {
+	p.lock.RLock()
+	spec, ok := p.clusters[in.Name]
+	p.lock.RUnlock()
+	if !ok {
+		return nil
+	}
+	if !spec.Limits.IsEmpty() {
+		out.CircuitBreakers = &envoycluster.CircuitBreakers{
+			Thresholds: []*envoycluster.CircuitBreakers_Thresholds{{
+				Priority:           envoycore.RoutingPriority_DEFAULT,
+				MaxConnections:     uint32Value(spec.Limits.MaxConnections),
+				MaxPendingRequests: uint32Value(spec.Limits.MaxPendingRequests),
+				MaxRequests:        uint32Value(spec.Limits.MaxRequests),
+				MaxRetries:         uint32Value(spec.Limits.MaxRetries),
+			}},
+		}
+	}
+	if spec.HTTP2 {
+		out.Http2ProtocolOptions = &envoycore.Http2ProtocolOptions{}
+	}
+	return nil
+}
+
+// zero keeps envoy's default
+func uint32Value(v uint32) *types.UInt32Value {
+	if v == 0 {
+		return nil
+	}
+	return &types.UInt32Value{Value: v}
+}
+
+const localServiceConnectTimeout = 5 * time.Second
+
+func localServiceCluster(listenerName string) string {
+	return listenerName + "-local-service"
+}
+
+func localServiceAddress(address string) (*envoycore.Address, error) {
+	if path, ok := consul.UnixSocketPath(address); ok {
+		return &envoycore.Address{
+			Address: &envoycore.Address_Pipe{
+				Pipe: &envoycore.Pipe{Path: path},
+			},
+		}, nil
+	}
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 32)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid port in %v", address)
+	}
+	return &envoycore.Address{
+		Address: &envoycore.Address_SocketAddress{
+			SocketAddress: &envoycore.SocketAddress{
+				Protocol: envoycore.TCP,
+				Address:  host,
+				PortSpecifier: &envoycore.SocketAddress_PortValue{
+					PortValue: uint32(port),
+				},
+			},
+		},
+	}, nil
+}
@@ -0,0 +1,167 @@
+package gloo
+
+import (
+	"context"
+	"sync"
+
+	"github.com/solo-io/gloo-connect/pkg/policy"
+	"github.com/solo-io/gloo/pkg/api/types/v1"
+	"github.com/solo-io/gloo/pkg/log"
+	"github.com/solo-io/gloo/pkg/storage"
+)
+
+// Policies tracks which services have a policy of their own for a
+// destination. The config writer labels the outbound listeners with it, so
+// that policy takes precedence over the all-origins one.
+type Policies struct {
+	lock sync.Mutex
+	// virtual services of the origin policies, by policy name
+	origins         map[string][]string
+	virtualServices map[string]bool
+	// the origin policies whose virtual services exist
+	active  map[string]bool
+	changes chan struct{}
+}
+
+// attributes and virtual services are watched until ctx is cancelled
+func WatchPolicies(ctx context.Context, gloo storage.Interface) (*Policies, error) {
+	p := &Policies{
+		origins:         make(map[string][]string),
+		virtualServices: make(map[string]bool),
+		active:          make(map[string]bool),
+		changes:         make(chan struct{}, 1),
+	}
+	attributes, err := gloo.V1().Attributes().Watch(&policiesEventHandler{policies: p})
+	if err != nil {
+		return nil, err
+	}
+	virtualServices, err := gloo.V1().VirtualServices().Watch(&policyVirtualServicesEventHandler{policies: p})
+	if err != nil {
+		return nil, err
+	}
+	errs := make(chan error)
+	go attributes.Run(ctx.Done(), errs)
+	go virtualServices.Run(ctx.Done(), errs)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case err := <-errs:
+				log.Warnf("error watching policies: %v", err)
+			}
+		}
+	}()
+	return p, nil
+}
+
+func (p *Policies) setAttributes(attributes []*v1.Attribute) {
+	origins := make(map[string][]string)
+	for _, attribute := range attributes {
+		listenerAttribute := attribute.GetListenerAttribute()
+		if listenerAttribute == nil {
+			continue
+		}
+		// policies written before the policy label, or by hand, don't take precedence
+		selector := listenerAttribute.Selector
+		if selector[policy.LabelPolicy] != policy.Origin {
+			continue
+		}
+		if attribute.Name != policy.Name(selector[policy.LabelService], selector[policy.LabelDestination]) {
+			continue
+		}
+		origins[attribute.Name] = listenerAttribute.VirtualServices
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.origins = origins
+	p.update()
+}
+
+func (p *Policies) setVirtualServices(virtualServices []*v1.VirtualService) {
+	names := make(map[string]bool)
+	for _, vs := range virtualServices {
+		names[vs.Name] = true
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.virtualServices = names
+	p.update()
+}
+
+// update recomputes the active policies; the lock has to be held
+func (p *Policies) update() {
+	active := make(map[string]bool)
+	for name, virtualServices := range p.origins {
+		if len(virtualServices) > 0 && p.allExist(virtualServices) {
+			active[name] = true
+		}
+	}
+	changed := len(active) != len(p.active)
+	for name := range active {
+		if !p.active[name] {
+			changed = true
+		}
+	}
+	p.active = active
+	if changed {
+		select {
+		case p.changes <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (p *Policies) allExist(virtualServices []string) bool {
+	for _, name := range virtualServices {
+		if !p.virtualServices[name] {
+			return false
+		}
+	}
+	return true
+}
+
+// HasOriginPolicy returns true if origin has a policy of its own for
+// destination, and the policy's virtual service exists
+func (p *Policies) HasOriginPolicy(origin, destination string) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.active[policy.Name(origin, destination)]
+}
+
+// Changes is signalled when policies were added or removed
+func (p *Policies) Changes() <-chan struct{} {
+	return p.changes
+}
+
+type policiesEventHandler struct {
+	policies *Policies
+}
+
+func (h *policiesEventHandler) OnAdd(updatedList []*v1.Attribute, _ *v1.Attribute) {
+	h.policies.setAttributes(updatedList)
+}
+
+func (h *policiesEventHandler) OnUpdate(updatedList []*v1.Attribute, _ *v1.Attribute) {
+	h.policies.setAttributes(updatedList)
+}
+
+func (h *policiesEventHandler) OnDelete(updatedList []*v1.Attribute, _ *v1.Attribute) {
+	h.policies.setAttributes(updatedList)
+}
+
+type policyVirtualServicesEventHandler struct {
+	policies *Policies
+}
+
+func (h *policyVirtualServicesEventHandler) OnAdd(updatedList []*v1.VirtualService, _ *v1.VirtualService) {
+	h.policies.setVirtualServices(updatedList)
+}
+
+func (h *policyVirtualServicesEventHandler) OnUpdate(updatedList []*v1.VirtualService, _ *v1.VirtualService) {
+	h.policies.setVirtualServices(updatedList)
+}
+
+func (h *policyVirtualServicesEventHandler) OnDelete(updatedList []*v1.VirtualService, _ *v1.VirtualService) {
+	h.policies.setVirtualServices(updatedList)
+}
@@ -0,0 +1,105 @@
+package gloo
+
+import (
+	"context"
+	"sync"
+
+	"github.com/solo-io/gloo-connect/pkg/consul"
+	"github.com/solo-io/gloo/pkg/api/types/v1"
+	"github.com/solo-io/gloo/pkg/coreplugins/static"
+	"github.com/solo-io/gloo/pkg/log"
+	pconsul "github.com/solo-io/gloo/pkg/plugins/consul"
+	"github.com/solo-io/gloo/pkg/storage"
+)
+
+// UpstreamResolver keeps a static gloo upstream in sync with the endpoints
+// of every proxy upstream that discovery can't resolve, i.e. prepared queries
+// and services in other datacenters
+type UpstreamResolver struct {
+	ctx     context.Context
+	gloo    storage.Interface
+	health  consul.HealthClient
+	queries consul.PreparedQueryClient
+
+	lock    sync.Mutex
+	watches map[string]context.CancelFunc
+}
+
+// upstreams are resolved until ctx is cancelled
+func NewUpstreamResolver(ctx context.Context, gloo storage.Interface, health consul.HealthClient, queries consul.PreparedQueryClient) *UpstreamResolver {
+	return &UpstreamResolver{
+		ctx:     ctx,
+		gloo:    gloo,
+		health:  health,
+		queries: queries,
+		watches: make(map[string]context.CancelFunc),
+	}
+}
+
+// Sync starts resolving new upstreams, and stops resolving and deletes the
+// gloo upstreams of removed ones
+func (r *UpstreamResolver) Sync(upstreams []consul.Upstream) {
+	desired := make(map[string]consul.Upstream)
+	for _, upstream := range upstreams {
+		if upstream.NeedsResolution() {
+			desired[upstream.Name()] = upstream
+		}
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for name, cancel := range r.watches {
+		if _, ok := desired[name]; ok {
+			continue
+		}
+		cancel()
+		delete(r.watches, name)
+		if err := r.gloo.V1().Upstreams().Delete(pconsul.UpstreamNameForConnectService(name)); err != nil {
+			log.Warnf("failed to delete resolved upstream %v: %v", name, err)
+		}
+	}
+	for name, upstream := range desired {
+		if _, ok := r.watches[name]; ok {
+			continue
+		}
+		ctx, cancel := context.WithCancel(r.ctx)
+		r.watches[name] = cancel
+		upstream := upstream
+		go consul.WatchEndpoints(ctx, r.health, r.queries, upstream, func(endpoints []consul.Endpoint) {
+			// Sync cancels the watch under the lock before it deletes the
+			// upstream, so a callback already in flight can't recreate it
+			r.lock.Lock()
+			defer r.lock.Unlock()
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("resolved %v to %v endpoints", upstream.Name(), len(endpoints))
+			if err := r.writeUpstream(upstream, endpoints); err != nil {
+				log.Warnf("failed to write resolved upstream %v: %v", upstream.Name(), err)
+			}
+		})
+	}
+}
+
+func (r *UpstreamResolver) writeUpstream(upstream consul.Upstream, endpoints []consul.Endpoint) error {
+	var spec static.UpstreamSpec
+	for _, endpoint := range endpoints {
+		spec.Hosts = append(spec.Hosts, static.Host{
+			Addr: endpoint.Address,
+			Port: endpoint.Port,
+		})
+	}
+	us := &v1.Upstream{
+		Name: pconsul.UpstreamNameForConnectService(upstream.Name()),
+		Type: static.UpstreamTypeStatic,
+		Spec: static.EncodeUpstreamSpec(spec),
+	}
+	existing, err := r.gloo.V1().Upstreams().Get(us.Name)
+	if err != nil {
+		_, err = r.gloo.V1().Upstreams().Create(us)
+		return err
+	}
+	us.Metadata = existing.Metadata
+	_, err = r.gloo.V1().Upstreams().Update(us)
+	return err
+}
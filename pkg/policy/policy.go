@@ -0,0 +1,37 @@
+package policy
+
+import "fmt"
+
+// mesh policies are a virtual service per origin and destination, and an
+// attribute that selects the outbound listeners they apply to by these labels
+const (
+	LabelService     = "service"
+	LabelDestination = "destination"
+	// whether the listener gets the all-origins policy of its destination,
+	// or the one specific to its service
+	LabelPolicy = "policy"
+
+	AllOrigins = "all-origins"
+	Origin     = "origin"
+)
+
+// Name is the name of the virtual service and the attribute of a policy
+func Name(origin, destination string) string {
+	return fmt.Sprintf("%v-to-%v-routes", origin, destination)
+}
+
+// Selector selects the outbound listeners a policy applies to. A policy for
+// an origin takes precedence over the all-origins policy of the destination.
+func Selector(origin, destination string) map[string]string {
+	if origin == AllOrigins {
+		return map[string]string{
+			LabelDestination: destination,
+			LabelPolicy:      AllOrigins,
+		}
+	}
+	return map[string]string{
+		LabelService:     origin,
+		LabelDestination: destination,
+		LabelPolicy:      Origin,
+	}
+}
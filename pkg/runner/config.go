@@ -1,14 +1,26 @@
 package runner
 
 import (
+	"time"
+
 	"github.com/solo-io/gloo/pkg/bootstrap"
 )
 
 type RunConfig struct {
-	Options     bootstrap.Options
-	GlooAddress string
-	GlooPort    uint
-	UseUDS      bool
-	ConfigDir   string
-	EnvoyPath   string
+	Options             bootstrap.Options
+	GlooAddress         string
+	GlooPort            uint
+	UseUDS              bool
+	ConfigDir           string
+	EnvoyPath           string
+	AuthorizeAuditLog   string
+	HealthGating        bool
+	EnvoyAdminPort      uint
+	SelfCheckTTL        time.Duration
+	DrainTime           time.Duration
+	MaxSyncFailures     int
+	SyncQuietPeriod     time.Duration
+	SyncMaxDelay        time.Duration
+	DebugAddress        string
+	DiscoverAllServices bool
 }
@@ -3,6 +3,7 @@ package runner
 import (
 	"context"
 	"errors"
+	"io"
 	"io/ioutil"
 	"math/rand"
 	"net"
@@ -12,14 +13,19 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	envoycore "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
 	"github.com/hashicorp/consul/api"
 
+	"github.com/solo-io/gloo-connect/pkg/authorize"
+	"github.com/solo-io/gloo-connect/pkg/coalesce"
 	"github.com/solo-io/gloo-connect/pkg/consul"
+	"github.com/solo-io/gloo-connect/pkg/debug"
 	"github.com/solo-io/gloo-connect/pkg/envoy"
 	"github.com/solo-io/gloo-connect/pkg/gloo"
+	"github.com/solo-io/gloo-connect/pkg/status"
 	"github.com/solo-io/gloo-connect/pkg/types"
 
 	"math"
@@ -31,6 +37,7 @@ import (
 	controlplane "github.com/solo-io/gloo/pkg/control-plane/bootstrap"
 	"github.com/solo-io/gloo/pkg/control-plane/eventloop"
 	"github.com/solo-io/gloo/pkg/log"
+	"github.com/solo-io/gloo/pkg/plugins"
 	pconsul "github.com/solo-io/gloo/pkg/plugins/consul"
 	"github.com/solo-io/gloo/pkg/storage"
 	"github.com/solo-io/gloo/pkg/storage/dependencies"
@@ -56,16 +63,18 @@ func randomStringForUDS() string {
 	return sb.String()
 }
 
-func cancelOnTerm(ctx context.Context) (context.Context, context.CancelFunc) {
-	ctx, cancel := context.WithCancel(ctx)
+// notifyOnTerm returns a channel that is closed on SIGINT or SIGTERM. a
+// second signal kills the bridge.
+func notifyOnTerm() <-chan struct{} {
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	term := make(chan struct{})
 	go func() {
 		<-c
-		signal.Reset(os.Interrupt)
-		cancel()
+		signal.Reset(os.Interrupt, syscall.SIGTERM)
+		close(term)
 	}()
-	return ctx, cancel
+	return term
 }
 
 func updateCerts(secrets dependencies.SecretStorage, rootCas types.Certificates, leafCert types.CertificateAndKey) error {
@@ -89,13 +98,59 @@ func updateCerts(secrets dependencies.SecretStorage, rootCas types.Certificates,
 	return nil
 }
 
+// writeCerts writes the certificates for envoy and records the result in the status
+func writeCerts(secrets dependencies.SecretStorage, bridgeStatus *status.Status, rootCas types.Certificates, leafCert types.CertificateAndKey) error {
+	certificates := &status.Certificates{}
+	err := updateCerts(secrets, rootCas, leafCert)
+	if err == nil {
+		certificates.LeafNotAfter, err = leafCert.Certificate.NotAfter()
+	}
+	if err != nil {
+		certificates.Error = err.Error()
+		certificates.ConsecutiveFailures = 1
+		if previous := bridgeStatus.Get().Certificates; previous != nil {
+			certificates.ConsecutiveFailures += previous.ConsecutiveFailures
+		}
+	}
+	bridgeStatus.SetCertificates(certificates)
+	return err
+}
+
+// failureLimit ends the bridge after too many consecutive failures of one
+// kind; a max of 0 never does
+type failureLimit struct {
+	max   int
+	fatal chan<- error
+}
+
+func (l failureLimit) check(what string, failures int) {
+	if l.max <= 0 || failures < l.max {
+		return
+	}
+	select {
+	case l.fatal <- pkgerrs.Errorf("%v failed %v times in a row", what, failures):
+	default:
+	}
+}
+
+// how many lines of logs the bridge keeps for debug bundles
+const debugLogLines = 2000
+
 func Run(runConfig RunConfig, store storage.Interface) error {
+	var logs, envoyLogs *debug.LogBuffer
+	if runConfig.DebugAddress != "" {
+		logs = debug.NewLogBuffer(debugLogLines)
+		log.DefaultOut = io.MultiWriter(log.DefaultOut, logs)
+		envoyLogs = debug.NewLogBuffer(debugLogLines)
+	}
+
 	if runConfig.ConfigDir == "" {
 		var err error
 		runConfig.ConfigDir, err = ioutil.TempDir("", "")
 		if err != nil {
 			return err
 		}
+		// signals are handled and errors are returned, so this runs on every exit
 		defer os.RemoveAll(runConfig.ConfigDir)
 	}
 
@@ -148,7 +203,10 @@ func Run(runConfig RunConfig, store storage.Interface) error {
 			defer os.Remove(unixsock)
 		}
 	} else {
-		glooXdsAddr = &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: int(runConfig.GlooPort)}
+		glooXdsAddr, err = net.ResolveTCPAddr("tcp", net.JoinHostPort(runConfig.GlooAddress, strconv.Itoa(int(runConfig.GlooPort))))
+		if err != nil {
+			return pkgerrs.Wrap(err, "resolving gloo address")
+		}
 	}
 
 	log.Printf("Using address %v", glooXdsAddr)
@@ -161,6 +219,10 @@ func Run(runConfig RunConfig, store storage.Interface) error {
 		XdsBindAddress: glooXdsAddr,
 	}
 
+	// applies the proxy config the connect plugin has no settings for
+	plugin := gloo.NewPlugin()
+	plugins.Register(plugin)
+
 	controlPlane, err := eventloop.SetupWithConfig(eventloopCfg)
 	if err != nil {
 		return pkgerrs.Wrap(err, "creating control-plane event loop")
@@ -177,30 +239,179 @@ func Run(runConfig RunConfig, store storage.Interface) error {
 		port = uint32(port32)
 	}
 
+	// the bridge shuts down in order on a signal, so ctx is only cancelled
+	// once the stores are cleaned up
+	term := notifyOnTerm()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	bridgeStatus := status.New()
+
+	log.Printf("starting local authorizer")
+	consulCfg.Token = cfg.Token()
+	consulClient, err := api.NewClient(consulCfg)
+	if err != nil {
+		return err
+	}
+	// the failure policy depends on the status code of the agent's answer,
+	// which the api client only puts in the error message
+	agent, err := authorize.NewHTTPAgent(consulCfg)
+	if err != nil {
+		return err
+	}
+	authorizer := authorize.NewServer(ctx, agent, consulClient.Connect())
+	if runConfig.AuthorizeAuditLog != "" {
+		auditLog, err := authorize.OpenAuditLog(runConfig.AuthorizeAuditLog)
+		if err != nil {
+			return pkgerrs.Wrap(err, "opening authorize audit log")
+		}
+		defer auditLog.Close()
+		authorizer.SetAuditLog(auditLog)
+	}
+	authorizerListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return pkgerrs.Wrap(err, "creating local authorizer listener")
+	}
+	go func() {
+		if err := authorizer.Serve(ctx, authorizerListener); err != nil {
+			log.Warnf("local authorizer stopped: %v", err)
+		}
+	}()
+	authorizerAddr := authorizerListener.Addr().(*net.TCPAddr)
+
+	// resolves prepared queries and services in other datacenters
+	resolver := gloo.NewUpstreamResolver(ctx, store, consulClient.Health(), consulClient.PreparedQuery())
+
+	// upstream discovery only writes the upstreams the proxy routes to
+	discoveryStore := store
+	var discoveryScope *gloo.DiscoveryScope
+	var widened <-chan struct{}
+	if !runConfig.DiscoverAllServices {
+		scoped := localstorage.NewScopedConfig(store)
+		discoveryScope, err = gloo.NewDiscoveryScope(ctx, store, scoped)
+		if err != nil {
+			return pkgerrs.Wrap(err, "watching policies for the discovery scope")
+		}
+		discoveryStore = scoped
+		widened = scoped.Widened()
+	}
+
+	var healthGate *gloo.HealthGate
+	if runConfig.HealthGating {
+		healthGate = gloo.NewHealthGate(ctx, consulClient.Health(), getNodeName())
+	}
+
+	// policies for the proxy's service take precedence over the all-origins ones
+	policies, err := gloo.WatchPolicies(ctx, store)
+	if err != nil {
+		return pkgerrs.Wrap(err, "watching policies")
+	}
+
 	log.Printf("creating config writer")
 
 	rolename, configWriter := gloo.NewConfigWriter(store, cfg, gloo.ConsulInfo{
-		ConsulHostname: addr,
-		ConsulPort:     port,
-		AuthorizePath:  "/v1/agent/connect/authorize",
-		ConfigDir:      runConfig.ConfigDir,
+		ConsulHostname:     addr,
+		ConsulPort:         port,
+		AuthorizePath:      authorize.Path,
+		AuthorizerHostname: authorizerAddr.IP.String(),
+		AuthorizerPort:     uint32(authorizerAddr.Port),
+		ConfigDir:          runConfig.ConfigDir,
+	}, gloo.Components{
+		Authorizer:     authorizer,
+		Resolver:       resolver,
+		HealthGate:     healthGate,
+		DiscoveryScope: discoveryScope,
+		Policies:       policies,
+		Plugin:         plugin,
+		Status:         bridgeStatus,
 	})
+	go resyncOnChange(ctx, "policy", policies.Changes(), configWriter)
+	go resyncOnChange(ctx, "intention", authorizer.IntentionChanges(), configWriter)
 
-	ctx := context.Background()
-	ctx, cancelTerm := cancelOnTerm(ctx)
-	defer cancelTerm()
+	id := &envoycore.Node{
+		Id:      rolename + "~" + getNodeName(),
+		Cluster: cfg.ProxyId(),
+	}
+
+	e := envoy.NewEnvoy(runConfig.EnvoyPath, glooXdsAddr, id)
+
+	sd := NewShutdown(configWriter, secrets, runConfig.DrainTime)
+	if runConfig.SelfCheckTTL > 0 {
+		sd.SetCheck(consulClient.Agent(), consul.ProxyCheckID(cfg.ProxyId()))
+	}
+	defer sd.Run()
+
+	// errors that end the bridge
+	fatal := make(chan error, 1)
+	limit := failureLimit{
+		max:   runConfig.MaxSyncFailures,
+		fatal: fatal,
+	}
+
+	// changes to the certificates and the proxy config are applied in batches
+	coalescer := coalesce.New(runConfig.SyncQuietPeriod, runConfig.SyncMaxDelay, func(update coalesce.Update) error {
+		// the role references the certificates, so they go first
+		if update.CertsChanged {
+			if err := writeCerts(secrets, bridgeStatus, update.Roots, update.Leaf); err != nil {
+				limit.check("updating the certificates", bridgeStatus.Get().Certificates.ConsecutiveFailures)
+				return err
+			}
+		}
+		if update.ConfigChanged {
+			if err := configWriter.Write(update.Config); err != nil {
+				limit.check("syncing the role", bridgeStatus.Get().Role.ConsecutiveFailures)
+				return err
+			}
+		}
+		return nil
+	})
+	go coalescer.Run(ctx)
+
+	if runConfig.DebugAddress != "" {
+		if err := startDebugServer(ctx, runConfig.DebugAddress, debug.Sources{
+			Config:         store,
+			Secrets:        secrets,
+			EnvoyBootstrap: e.Bootstrap,
+			ProxyConfig:    coalescer.Config,
+			Status:         bridgeStatus,
+			EnvoyAdmin:     e.Admin,
+			Logs:           logs,
+			EnvoyLogs:      envoyLogs,
+		}); err != nil {
+			return err
+		}
+	}
 
 	log.Printf("creating cert fetcher")
-	cf, err := consul.NewCertificateFetcher(ctx, configWriter, cfg)
+	cf, err := consul.NewCertificateFetcher(ctx, coalescer, cfg)
 	if err != nil {
 		return err
 	}
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case roots := <-cf.RootCerts():
+				coalescer.SetRoots(roots)
+			case leaf := <-cf.Certs():
+				coalescer.SetLeaf(leaf)
+			}
+		}
+	}()
 
-	log.Printf("getting first copy of local certs")
-	// we need one root cert and client cert to begin:
-	rootcert := <-cf.RootCerts()
-	leaftcert := <-cf.Certs()
-	updateCerts(secrets, rootcert, leaftcert)
+	log.Printf("getting first copy of local certs and proxy config")
+	// we need one root cert, client cert and proxy config to begin:
+	select {
+	case <-coalescer.Ready():
+	case <-cf.ProxyDeregistered():
+		log.Printf("proxy was deregistered before it started")
+		return nil
+	case <-term:
+		return nil
+	case err := <-fatal:
+		return err
+	}
 
 	//create stop channel from context
 	stop := make(chan struct{})
@@ -209,25 +420,20 @@ func Run(runConfig RunConfig, store storage.Interface) error {
 		close(stop)
 	}()
 	go controlPlane.Run(stop)
-	go func() {
-		opts := bootstrap.Options{
-			Options: runConfig.Options,
-			UpstreamDiscoveryOptions: bootstrap.UpstreamDiscoveryOptions{
-				EnableDiscoveryForConsul: true,
-			},
-		}
-		if err := upstreamdiscovery.Start(opts, store, stop); err != nil {
-			log.Fatalf("failed to start upstream discovery: %v", err)
-		}
-	}()
+	go runDiscovery(ctx, bootstrap.Options{
+		Options: runConfig.Options,
+		UpstreamDiscoveryOptions: bootstrap.UpstreamDiscoveryOptions{
+			EnableDiscoveryForConsul: true,
+		},
+	}, discoveryStore, widened, fatal)
 
-	id := &envoycore.Node{
-		Id:      rolename + "~" + getNodeName(),
-		Cluster: cfg.ProxyId(),
+	envoyCfg := envoy.Config{
+		AdminPort: uint32(runConfig.EnvoyAdminPort),
+		DrainTime: runConfig.DrainTime,
+	}
+	if envoyLogs != nil {
+		envoyCfg.Output = envoyLogs
 	}
-
-	e := envoy.NewEnvoy(runConfig.EnvoyPath, glooXdsAddr, id)
-	envoyCfg := envoy.Config{}
 
 	log.Printf("writing envoy config")
 	err = e.WriteConfig(envoyCfg)
@@ -240,28 +446,169 @@ func Run(runConfig RunConfig, store storage.Interface) error {
 	if err != nil {
 		return errors.New("can't start envoy config")
 	}
+	sd.SetEnvoy(e)
 
-	ctx, cancel := context.WithCancel(ctx)
-	defer cancel()
+	if healthGate != nil {
+		go gateOnHealth(ctx, healthGate, configWriter, e)
+	}
+
+	if runConfig.SelfCheckTTL > 0 {
+		checkDone := make(chan struct{})
+		go func() {
+			defer close(checkDone)
+			probe := func() error {
+				if sd.Started() {
+					return errors.New("shutting down")
+				}
+				envoyStatus := &status.Envoy{}
+				state, err := e.ServerState()
+				envoyStatus.State = state
+				if err != nil {
+					envoyStatus.Error = err.Error()
+				}
+				bridgeStatus.SetEnvoy(envoyStatus)
+				return bridgeStatus.Get().Ready(time.Now())
+			}
+			if err := consul.RunProxyCheck(ctx, consulClient.Agent(), cfg.ProxyId(), runConfig.SelfCheckTTL, probe); err != nil {
+				log.Warnf("failed to run proxy check: %v", err)
+			}
+		}()
+		// deregister the check before exiting
+		defer func() {
+			cancel()
+			<-checkDone
+		}()
+	}
 
+	// the result of the bridge, once it decided to shut down
+	result := make(chan error, 1)
 	go func() {
-		defer cancel()
+		var err error
+		select {
+		case <-ctx.Done():
+			return
+		case <-term:
+			log.Printf("shutting down")
+		case <-cf.ProxyDeregistered():
+			log.Printf("proxy was deregistered; shutting down")
+		case err = <-fatal:
+			log.Warnf("shutting down: %v", err)
+		}
+		sd.Run()
+		result <- err
+		// stops envoy and the control plane
+		cancel()
+	}()
 
-		for {
+	err = e.Run(ctx)
+	// envoy is gone, there is nothing left to drain
+	sd.SetEnvoy(nil)
+	if err != nil {
+		return err
+	}
+	select {
+	case err := <-result:
+		return err
+	default:
+	}
+	return ctx.Err()
+}
+
+// the scope widens once per upstream and policy that is added, so restarts
+// are held back until it settles a little
+const discoveryRestartDelay = time.Second
+
+// runDiscovery runs upstream discovery until ctx is cancelled, and starts it
+// over whenever the scope widened, so it writes the new upstreams
+func runDiscovery(ctx context.Context, opts bootstrap.Options, store storage.Interface, widened <-chan struct{}, fatal chan<- error) {
+	for {
+		stop := make(chan struct{})
+		done := make(chan error, 1)
+		go func() {
+			done <- upstreamdiscovery.Start(opts, store, stop)
+		}()
+		restart := false
+		var restartAfter <-chan time.Time
+		for !restart {
 			select {
 			case <-ctx.Done():
+				close(stop)
 				return
-			case rootcert = <-cf.RootCerts():
-			case leaftcert = <-cf.Certs():
+			case err := <-done:
+				if err != nil {
+					close(stop)
+					select {
+					case fatal <- pkgerrs.Wrap(err, "starting upstream discovery"):
+					default:
+					}
+					return
+				}
+				// discovery keeps running in the background until stopped
+				done = nil
+			case <-widened:
+				// later widenings are picked up by the same restart
+				if restartAfter == nil {
+					restartAfter = time.After(discoveryRestartDelay)
+				}
+			case <-restartAfter:
+				log.Printf("restarting upstream discovery for new upstreams")
+				close(stop)
+				restart = true
 			}
-			updateCerts(secrets, rootcert, leaftcert)
 		}
-	}()
+	}
+}
 
-	if err := e.Run(ctx); err != nil {
+// startDebugServer serves the debug endpoints on a loopback address
+func startDebugServer(ctx context.Context, address string, sources debug.Sources) error {
+	if err := debug.ValidateAddress(address); err != nil {
 		return err
 	}
-	return ctx.Err()
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return pkgerrs.Wrap(err, "creating debug listener")
+	}
+	log.Printf("serving debug endpoints on %v", listener.Addr())
+	go func() {
+		if err := debug.NewServer(sources).Serve(ctx, listener); err != nil {
+			log.Warnf("debug server stopped: %v", err)
+		}
+	}()
+	return nil
+}
+
+// resyncOnChange syncs the role again on every change, e.g. to relabel the
+// outbound listeners when a policy for the proxy's service was added or
+// removed, or to render the intentions again
+func resyncOnChange(ctx context.Context, what string, changes <-chan struct{}, configWriter *gloo.ConfigWriter) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-changes:
+			if err := configWriter.Resync(); err != nil {
+				log.Warnf("failed to apply %v changes: %v", what, err)
+			}
+		}
+	}
+}
+
+// gateOnHealth removes the inbound listener and fails envoy's health checks
+// while the target service instance is unhealthy
+func gateOnHealth(ctx context.Context, healthGate *gloo.HealthGate, configWriter *gloo.ConfigWriter, e envoy.Envoy) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case healthy := <-healthGate.Changes():
+			if err := configWriter.Resync(); err != nil {
+				log.Warnf("failed to apply service health: %v", err)
+			}
+			if err := e.SetHealthy(healthy); err != nil {
+				log.Warnf("failed to set envoy health: %v", err)
+			}
+		}
+	}
 }
 
 func EventuallyReload(e envoy.Envoy) {
@@ -0,0 +1,112 @@
+package runner
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	pkgerrs "github.com/pkg/errors"
+	"github.com/solo-io/gloo-connect/pkg/consul"
+	"github.com/solo-io/gloo-connect/pkg/envoy"
+	"github.com/solo-io/gloo/pkg/log"
+	pconsul "github.com/solo-io/gloo/pkg/plugins/consul"
+	"github.com/solo-io/gloo/pkg/storage/dependencies"
+)
+
+// RoleRemover removes the role the bridge wrote, e.g. the gloo config writer
+type RoleRemover interface {
+	Remove() error
+}
+
+// Shutdown releases what the bridge created in the stores. Consul stops
+// routing to the proxy and envoy is drained first, so it stops taking
+// traffic before its config disappears. The control plane has to keep
+// running until shutdown is done, so envoy gets the removal of the role.
+type Shutdown struct {
+	roles     RoleRemover
+	secrets   dependencies.SecretStorage
+	drainTime time.Duration
+
+	lock sync.Mutex
+	// nil while envoy isn't running
+	envoy envoy.Envoy
+	// nil without a ttl check
+	checks  consul.CheckClient
+	checkID string
+	started bool
+	once    sync.Once
+}
+
+func NewShutdown(roles RoleRemover, secrets dependencies.SecretStorage, drainTime time.Duration) *Shutdown {
+	return &Shutdown{
+		roles:     roles,
+		secrets:   secrets,
+		drainTime: drainTime,
+	}
+}
+
+func (s *Shutdown) SetEnvoy(e envoy.Envoy) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.envoy = e
+}
+
+// SetCheck has the shutdown fail the bridge's ttl check before draining
+func (s *Shutdown) SetCheck(checks consul.CheckClient, checkID string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.checks = checks
+	s.checkID = checkID
+}
+
+// Started returns true once Run was called, so the check stays critical
+func (s *Shutdown) Started() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.started
+}
+
+// Run is safe to call more than once; only the first call does anything
+func (s *Shutdown) Run() {
+	s.once.Do(func() {
+		s.lock.Lock()
+		s.started = true
+		e, checks, checkID := s.envoy, s.checks, s.checkID
+		s.lock.Unlock()
+		if checks != nil {
+			if err := checks.UpdateTTL(checkID, "shutting down", api.HealthCritical); err != nil {
+				log.Warnf("failed to fail check %v: %v", checkID, err)
+			}
+		}
+		if e != nil {
+			log.Printf("draining envoy for %v", s.drainTime)
+			if err := e.SetHealthy(false); err != nil {
+				log.Warnf("failed to fail envoy health checks: %v", err)
+			}
+		}
+		// envoy drains the listeners of the removed role
+		if err := s.roles.Remove(); err != nil {
+			log.Warnf("failed to remove role: %v", err)
+		}
+		if e != nil {
+			time.Sleep(s.drainTime)
+		}
+		if err := removeCerts(s.secrets); err != nil {
+			log.Warnf("failed to remove certificates: %v", err)
+		}
+	})
+}
+
+// removeCerts deletes the certificate secret. Go strings can't be
+// overwritten, so this doesn't scrub the private key from memory or from
+// stores that keep deleted objects around.
+func removeCerts(secrets dependencies.SecretStorage) error {
+	if _, err := secrets.Get(pconsul.LeafCertificateSecret); err != nil {
+		// nothing was written yet
+		return nil
+	}
+	if err := secrets.Delete(pconsul.LeafCertificateSecret); err != nil {
+		return pkgerrs.Wrapf(err, "deleting secret %v", pconsul.LeafCertificateSecret)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package runner_test
+
+import (
+	"errors"
+
+	"github.com/hashicorp/consul/api"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/solo-io/gloo-connect/pkg/envoy"
+	. "github.com/solo-io/gloo-connect/pkg/runner"
+	"github.com/solo-io/gloo/pkg/storage/dependencies"
+)
+
+// steps records the order of the shutdown's calls
+type steps []string
+
+func (s *steps) add(step string) {
+	*s = append(*s, step)
+}
+
+type fakeChecks struct {
+	steps *steps
+}
+
+func (f *fakeChecks) CheckRegister(check *api.AgentCheckRegistration) error {
+	return nil
+}
+
+func (f *fakeChecks) CheckDeregister(checkID string) error {
+	return nil
+}
+
+func (f *fakeChecks) UpdateTTL(checkID, output, status string) error {
+	f.steps.add("check " + checkID + " " + status)
+	return nil
+}
+
+type fakeEnvoy struct {
+	envoy.Envoy
+	steps *steps
+}
+
+func (f *fakeEnvoy) SetHealthy(healthy bool) error {
+	if !healthy {
+		f.steps.add("envoy unhealthy")
+	}
+	return nil
+}
+
+type fakeRoles struct {
+	steps *steps
+}
+
+func (f *fakeRoles) Remove() error {
+	f.steps.add("role removed")
+	return nil
+}
+
+type fakeSecrets struct {
+	dependencies.SecretStorage
+}
+
+func (f *fakeSecrets) Get(name string) (*dependencies.Secret, error) {
+	return nil, errors.New("not found")
+}
+
+var _ = Describe("Shutdown", func() {
+	var (
+		recorded steps
+		sd       *Shutdown
+	)
+
+	BeforeEach(func() {
+		recorded = nil
+		sd = NewShutdown(&fakeRoles{steps: &recorded}, &fakeSecrets{}, 0)
+	})
+
+	It("fails the ttl check before draining envoy", func() {
+		sd.SetCheck(&fakeChecks{steps: &recorded}, "gloo-connect:web-proxy")
+		sd.SetEnvoy(&fakeEnvoy{steps: &recorded})
+		sd.Run()
+		Expect(recorded).To(Equal(steps{
+			"check gloo-connect:web-proxy critical",
+			"envoy unhealthy",
+			"role removed",
+		}))
+		Expect(sd.Started()).To(BeTrue())
+	})
+
+	It("only runs once", func() {
+		sd.Run()
+		sd.Run()
+		Expect(recorded).To(Equal(steps{"role removed"}))
+	})
+})
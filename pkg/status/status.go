@@ -0,0 +1,145 @@
+package status
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Status holds the current state of the bridge. It is updated by the
+// different parts of the bridge as they sync, and is safe for concurrent use.
+type Status struct {
+	lock    sync.RWMutex
+	current Snapshot
+}
+
+// Snapshot is a point-in-time copy of the bridge status
+type Snapshot struct {
+	// how inbound connections are authorized; nil for client-only proxies
+	Authorize *Authorize `json:"authorize,omitempty"`
+	// health of the target service instance; nil unless health gating is enabled
+	ServiceHealth *ServiceHealth `json:"service_health,omitempty"`
+	// nil until the first certificates were written
+	Certificates *Certificates `json:"certificates,omitempty"`
+	// nil until the first proxy config was applied
+	Role *Role `json:"role,omitempty"`
+	// nil until envoy was first probed
+	Envoy *Envoy `json:"envoy,omitempty"`
+}
+
+type Certificates struct {
+	// expiry of the leaf certificate
+	LeafNotAfter time.Time `json:"leaf_not_after"`
+	// error writing the certificates for envoy, if any
+	Error string `json:"error,omitempty"`
+	// failed writes since the last successful one
+	ConsecutiveFailures int `json:"consecutive_failures,omitempty"`
+}
+
+type Role struct {
+	Synced   bool      `json:"synced"`
+	LastSync time.Time `json:"last_sync"`
+	// error of the last sync, if any
+	Error string `json:"error,omitempty"`
+	// failed syncs since the last successful one
+	ConsecutiveFailures int `json:"consecutive_failures,omitempty"`
+}
+
+type Envoy struct {
+	// server state reported by the admin api, e.g. LIVE
+	State string `json:"state"`
+	// error probing envoy, if any
+	Error string `json:"error,omitempty"`
+}
+
+type ServiceHealth struct {
+	ServiceID string `json:"service_id"`
+	// the inbound listener is removed while the instance is unhealthy
+	Healthy bool `json:"healthy"`
+}
+
+type Authorize struct {
+	// address envoy sends authorize requests to
+	Address string `json:"address"`
+	// whether decisions come from the agent or from locally watched intentions
+	Mode string `json:"mode"`
+	// how long envoy waits for an authorize response
+	Timeout string `json:"timeout"`
+	// what happens when the agent can't be reached in time
+	FailurePolicy string `json:"failure_policy"`
+	// whether decisions are written to the audit log
+	Audited bool `json:"audited"`
+	// whether envoy decides from rbac rules rendered from the intentions
+	RBAC bool `json:"rbac"`
+}
+
+func New() *Status {
+	return &Status{}
+}
+
+func (s *Status) SetAuthorize(authorize *Authorize) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.current.Authorize = authorize
+}
+
+func (s *Status) SetServiceHealth(health *ServiceHealth) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.current.ServiceHealth = health
+}
+
+func (s *Status) SetCertificates(certificates *Certificates) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.current.Certificates = certificates
+}
+
+func (s *Status) SetRole(role *Role) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.current.Role = role
+}
+
+func (s *Status) SetEnvoy(envoy *Envoy) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.current.Envoy = envoy
+}
+
+func (s *Status) Get() Snapshot {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.current
+}
+
+const EnvoyStateLive = "LIVE"
+
+// Ready returns an error unless the certificates are current, the role is
+// synced and envoy is live, i.e. unless the proxy can serve mesh traffic
+func (s Snapshot) Ready(now time.Time) error {
+	switch {
+	case s.Certificates == nil:
+		return errors.New("certificates have not been fetched yet")
+	case s.Certificates.Error != "":
+		return errors.Errorf("writing certificates failed: %v", s.Certificates.Error)
+	case !now.Before(s.Certificates.LeafNotAfter):
+		return errors.Errorf("leaf certificate expired at %v", s.Certificates.LeafNotAfter)
+	}
+	switch {
+	case s.Role == nil:
+		return errors.New("role has not been synced yet")
+	case !s.Role.Synced:
+		return errors.Errorf("syncing role failed: %v", s.Role.Error)
+	}
+	switch {
+	case s.Envoy == nil:
+		return errors.New("envoy has not been probed yet")
+	case s.Envoy.Error != "":
+		return errors.Errorf("probing envoy failed: %v", s.Envoy.Error)
+	case s.Envoy.State != EnvoyStateLive:
+		return errors.Errorf("envoy is %v, not %v", s.Envoy.State, EnvoyStateLive)
+	}
+	return nil
+}
@@ -2,11 +2,12 @@ package storage
 
 import (
 	"sort"
+	"strconv"
 	"sync"
 
+	"github.com/gogo/protobuf/proto"
 	"github.com/pkg/errors"
 	"github.com/solo-io/gloo/pkg/api/types/v1"
-	"github.com/solo-io/gloo/pkg/log"
 	"github.com/solo-io/gloo/pkg/storage"
 )
 
@@ -50,12 +51,25 @@ func (s *PartialInMemoryConfig) Roles() storage.Roles {
 	return s.roles
 }
 
+// SetUpstreamOverrides sets the per-proxy settings applied to upstreams, by upstream name
+func (s *PartialInMemoryConfig) SetUpstreamOverrides(overrides map[string]UpstreamOverride) {
+	s.upstreams.setOverrides(overrides)
+}
+
 /**************************************
 	Upstreams
  *************************************/
 
+// UpstreamOverride changes an upstream as it is read from the store. Overrides
+// never change the stored object, so they survive writes from upstream discovery
+// and can be removed again.
+type UpstreamOverride func(us *v1.Upstream)
+
 type inMemoryUpstreams struct {
 	store *inMemoryStore
+
+	lock      sync.RWMutex
+	overrides map[string]UpstreamOverride
 }
 
 func newInMemoryUpstreams() *inMemoryUpstreams {
@@ -64,6 +78,34 @@ func newInMemoryUpstreams() *inMemoryUpstreams {
 	}
 }
 
+func (s *inMemoryUpstreams) setOverrides(overrides map[string]UpstreamOverride) {
+	s.lock.Lock()
+	s.overrides = overrides
+	s.lock.Unlock()
+	// let watchers see the new settings
+	s.store.resync()
+}
+
+func (s *inMemoryUpstreams) override(us *v1.Upstream) *v1.Upstream {
+	s.lock.RLock()
+	override, ok := s.overrides[us.Name]
+	s.lock.RUnlock()
+	if !ok {
+		return us
+	}
+	us = proto.Clone(us).(*v1.Upstream)
+	override(us)
+	return us
+}
+
+func (s *inMemoryUpstreams) overrideList(upstreams []*v1.Upstream) []*v1.Upstream {
+	var out []*v1.Upstream
+	for _, us := range upstreams {
+		out = append(out, s.override(us))
+	}
+	return out
+}
+
 func (s *inMemoryUpstreams) Create(obj *v1.Upstream) (*v1.Upstream, error) {
 	out, err := s.store.Create(obj)
 	if err != nil {
@@ -89,7 +131,7 @@ func (s *inMemoryUpstreams) Get(name string) (*v1.Upstream, error) {
 	if err != nil {
 		return nil, err
 	}
-	return out.(*v1.Upstream), nil
+	return s.override(out.(*v1.Upstream)), nil
 }
 
 func (s *inMemoryUpstreams) List() ([]*v1.Upstream, error) {
@@ -101,11 +143,40 @@ func (s *inMemoryUpstreams) List() ([]*v1.Upstream, error) {
 	for _, obj := range objects {
 		out = append(out, obj.(*v1.Upstream))
 	}
-	return out, nil
+	return s.overrideList(out), nil
 }
 
 func (s *inMemoryUpstreams) Watch(handlers ...storage.UpstreamEventHandler) (*storage.Watcher, error) {
-	return s.store.Watch(handlers, nil)
+	var overridden []storage.UpstreamEventHandler
+	for _, h := range handlers {
+		overridden = append(overridden, &overrideUpstreamEventHandler{upstreams: s, handler: h})
+	}
+	return s.store.Watch(overridden, nil)
+}
+
+// overrideUpstreamEventHandler applies the overrides to the upstreams a watch delivers
+type overrideUpstreamEventHandler struct {
+	upstreams *inMemoryUpstreams
+	handler   storage.UpstreamEventHandler
+}
+
+func (h *overrideUpstreamEventHandler) OnAdd(updatedList []*v1.Upstream, obj *v1.Upstream) {
+	h.handler.OnAdd(h.upstreams.overrideList(updatedList), h.overrideObj(obj))
+}
+
+func (h *overrideUpstreamEventHandler) OnUpdate(updatedList []*v1.Upstream, newObj *v1.Upstream) {
+	h.handler.OnUpdate(h.upstreams.overrideList(updatedList), h.overrideObj(newObj))
+}
+
+func (h *overrideUpstreamEventHandler) OnDelete(updatedList []*v1.Upstream, obj *v1.Upstream) {
+	h.handler.OnDelete(h.upstreams.overrideList(updatedList), obj)
+}
+
+func (h *overrideUpstreamEventHandler) overrideObj(obj *v1.Upstream) *v1.Upstream {
+	if obj == nil {
+		return nil
+	}
+	return h.upstreams.override(obj)
 }
 
 /**************************************
@@ -170,23 +241,99 @@ func (s *inMemoryRoles) Watch(handlers ...storage.RoleEventHandler) (*storage.Wa
 	Base
  *************************************/
 
+// ConflictErr is returned when an object is updated from a stale resource version
+type ConflictErr struct {
+	error
+}
+
+// IsConflict also sees through wrapped errors
+func IsConflict(err error) bool {
+	_, ok := errors.Cause(err).(*ConflictErr)
+	return ok
+}
+
+// inMemoryStore keeps deep copies of its objects, and versions them like the
+// consul-backed gloo storage: every write bumps the resource version, and
+// updates have to start from the current version
 type inMemoryStore struct {
 	lock    sync.RWMutex
 	objects map[string]v1.ConfigObject
-	updates chan struct{}
+	version uint64
+	watches *watchQueues
 }
 
 func newInMemoryStore() *inMemoryStore {
 	return &inMemoryStore{
 		objects: make(map[string]v1.ConfigObject),
-		updates: make(chan struct{}, 10),
+		watches: newWatchQueues(),
+	}
+}
+
+// snapshot returns the stored objects sorted by name; the caller holds the lock
+func (s *inMemoryStore) snapshot() []interface{} {
+	var names []string
+	for name := range s.objects {
+		names = append(names, name)
 	}
+	sort.Strings(names)
+	var objs []interface{}
+	for _, name := range names {
+		objs = append(objs, s.objects[name])
+	}
+	return objs
+}
+
+// notify queues an event for every watcher; the caller holds the write lock
+func (s *inMemoryStore) notify(typ eventType, obj v1.ConfigObject) {
+	s.watches.notify(event{typ: typ, obj: obj, list: s.snapshot()})
+}
+
+// resync sends the full state to every watcher
+func (s *inMemoryStore) resync() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.watches.notify(event{typ: eventResync, list: s.snapshot()})
 }
 
-func (s *inMemoryStore) updated() {
-	go func() {
-		s.updates <- struct{}{}
-	}()
+func clone(obj v1.ConfigObject) v1.ConfigObject {
+	return proto.Clone(obj).(v1.ConfigObject)
+}
+
+func resourceVersion(obj v1.ConfigObject) string {
+	if obj.GetMetadata() == nil {
+		return ""
+	}
+	return obj.GetMetadata().ResourceVersion
+}
+
+func setResourceVersion(obj v1.ConfigObject, version string) {
+	var meta *v1.Metadata
+	switch obj := obj.(type) {
+	case *v1.Upstream:
+		if obj.Metadata == nil {
+			obj.Metadata = &v1.Metadata{}
+		}
+		meta = obj.Metadata
+	case *v1.Role:
+		if obj.Metadata == nil {
+			obj.Metadata = &v1.Metadata{}
+		}
+		meta = obj.Metadata
+	default:
+		panic(errors.Errorf("internal error: unsupported config object %T", obj))
+	}
+	meta.ResourceVersion = version
+}
+
+// store writes a copy of obj with the next resource version; the caller holds the lock.
+// stored objects are never modified, so watch events can share them.
+func (s *inMemoryStore) store(typ eventType, obj v1.ConfigObject) v1.ConfigObject {
+	s.version++
+	stored := clone(obj)
+	setResourceVersion(stored, strconv.FormatUint(s.version, 10))
+	s.objects[stored.GetName()] = stored
+	s.notify(typ, stored)
+	return clone(stored)
 }
 
 func (s *inMemoryStore) Create(obj v1.ConfigObject) (v1.ConfigObject, error) {
@@ -195,30 +342,36 @@ func (s *inMemoryStore) Create(obj v1.ConfigObject) (v1.ConfigObject, error) {
 	if _, exists := s.objects[obj.GetName()]; exists {
 		return nil, storage.NewAlreadyExistsErr(errors.Errorf("%s already exists", obj.GetName()))
 	}
-	s.objects[obj.GetName()] = obj
-	s.updated()
-	return obj, nil
+	return s.store(eventAdd, obj), nil
 }
 
 func (s *inMemoryStore) Update(obj v1.ConfigObject) (v1.ConfigObject, error) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
-	if _, exists := s.objects[obj.GetName()]; !exists {
+	existing, exists := s.objects[obj.GetName()]
+	if !exists {
 		return nil, errors.Errorf("%s does not exist", obj.GetName())
 	}
-	s.objects[obj.GetName()] = obj
-	s.updated()
-	return obj, nil
+	version := resourceVersion(obj)
+	if version == "" {
+		return nil, errors.New("resource version must be set for update operations")
+	}
+	if version != resourceVersion(existing) {
+		return nil, &ConflictErr{errors.Errorf("%s was updated concurrently: resource version is %v, not %v",
+			obj.GetName(), resourceVersion(existing), version)}
+	}
+	return s.store(eventUpdate, obj), nil
 }
 
 func (s *inMemoryStore) Delete(name string) error {
 	s.lock.Lock()
 	defer s.lock.Unlock()
-	if _, exists := s.objects[name]; !exists {
+	existing, exists := s.objects[name]
+	if !exists {
 		return errors.Errorf("%s does not exist", name)
 	}
 	delete(s.objects, name)
-	s.updated()
+	s.notify(eventDelete, existing)
 	return nil
 }
 
@@ -229,7 +382,7 @@ func (s *inMemoryStore) Get(name string) (v1.ConfigObject, error) {
 	if !exists {
 		return nil, errors.Errorf("%s does not exist", name)
 	}
-	return obj, nil
+	return clone(obj), nil
 }
 
 func (s *inMemoryStore) List() ([]v1.ConfigObject, error) {
@@ -237,7 +390,7 @@ func (s *inMemoryStore) List() ([]v1.ConfigObject, error) {
 	defer s.lock.RUnlock()
 	var objs []v1.ConfigObject
 	for _, obj := range s.objects {
-		objs = append(objs, obj)
+		objs = append(objs, clone(obj))
 	}
 	sort.SliceStable(objs, func(i, j int) bool {
 		return objs[i].GetName() < objs[j].GetName()
@@ -245,47 +398,67 @@ func (s *inMemoryStore) List() ([]v1.ConfigObject, error) {
 	return objs, nil
 }
 
+// Watch sends the current state to the handlers first, and then every change in order
 func (s *inMemoryStore) Watch(upstreamHandlers []storage.UpstreamEventHandler, roleHandlers []storage.RoleEventHandler) (*storage.Watcher, error) {
 	if len(upstreamHandlers) > 0 && len(roleHandlers) > 0 {
 		return nil, errors.Errorf("internal error: can only specify role event handlers or upstream event handlers, "+
 			"got %v upstream and %v role event handlers", len(upstreamHandlers), len(roleHandlers))
 	}
+	if len(upstreamHandlers) == 0 && len(roleHandlers) == 0 {
+		return nil, errors.New("internal error: no handlers specified")
+	}
+	s.lock.RLock()
+	q := s.watches.add(s.snapshot())
+	s.lock.RUnlock()
 	return storage.NewWatcher(func(stop <-chan struct{}, errs chan error) {
-		for {
-			select {
-			case <-s.updates:
-				objs, err := s.List()
-				if err != nil {
-					log.Warnf("failed to list config objects: %v", err)
-					continue
-				}
-				switch {
-				case len(upstreamHandlers) > 0:
-					var upstreams []*v1.Upstream
-					for _, obj := range objs {
-						upstreams = append(upstreams, obj.(*v1.Upstream))
-					}
-					for _, h := range upstreamHandlers {
-						h.OnUpdate(upstreams, nil)
-					}
-				case len(roleHandlers) > 0:
-					var roles []*v1.Role
-					for _, obj := range objs {
-						roles = append(roles, obj.(*v1.Role))
-					}
-					for _, h := range roleHandlers {
-						h.OnUpdate(roles, nil)
-					}
-				default:
-					// no handlers specified
-					return
-				}
-			case err := <-errs:
-				log.Warnf("failed to start watcher: %v", err)
-				return
-			case <-stop:
+		s.watches.watch(q, stop, func(e event) {
+			if len(upstreamHandlers) > 0 {
+				deliverUpstreamEvent(e, upstreamHandlers)
 				return
 			}
-		}
+			deliverRoleEvent(e, roleHandlers)
+		})
 	}), nil
 }
+
+func deliverUpstreamEvent(e event, handlers []storage.UpstreamEventHandler) {
+	var list []*v1.Upstream
+	for _, obj := range e.list {
+		list = append(list, clone(obj.(v1.ConfigObject)).(*v1.Upstream))
+	}
+	var obj *v1.Upstream
+	if e.obj != nil {
+		obj = clone(e.obj.(v1.ConfigObject)).(*v1.Upstream)
+	}
+	for _, h := range handlers {
+		switch e.typ {
+		case eventAdd:
+			h.OnAdd(list, obj)
+		case eventDelete:
+			h.OnDelete(list, obj)
+		default:
+			h.OnUpdate(list, obj)
+		}
+	}
+}
+
+func deliverRoleEvent(e event, handlers []storage.RoleEventHandler) {
+	var list []*v1.Role
+	for _, obj := range e.list {
+		list = append(list, clone(obj.(v1.ConfigObject)).(*v1.Role))
+	}
+	var obj *v1.Role
+	if e.obj != nil {
+		obj = clone(e.obj.(v1.ConfigObject)).(*v1.Role)
+	}
+	for _, h := range handlers {
+		switch e.typ {
+		case eventAdd:
+			h.OnAdd(list, obj)
+		case eventDelete:
+			h.OnDelete(list, obj)
+		default:
+			h.OnUpdate(list, obj)
+		}
+	}
+}
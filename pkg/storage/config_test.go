@@ -0,0 +1,112 @@
+package storage_test
+
+import (
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+
+	. "github.com/solo-io/gloo-connect/pkg/storage"
+	"github.com/solo-io/gloo/pkg/api/types/v1"
+)
+
+// recordingRoleHandler records the events it gets as "<event> <role> <number of roles>"
+type recordingRoleHandler struct {
+	events chan string
+}
+
+func (h *recordingRoleHandler) record(event string, list []*v1.Role, obj *v1.Role) {
+	name := "-"
+	if obj != nil {
+		name = obj.Name
+	}
+	h.events <- fmt.Sprintf("%v %v %v", event, name, len(list))
+}
+
+func (h *recordingRoleHandler) OnAdd(list []*v1.Role, obj *v1.Role)    { h.record("add", list, obj) }
+func (h *recordingRoleHandler) OnUpdate(list []*v1.Role, obj *v1.Role) { h.record("update", list, obj) }
+func (h *recordingRoleHandler) OnDelete(list []*v1.Role, obj *v1.Role) { h.record("delete", list, obj) }
+
+var _ = Describe("PartialInMemoryConfig", func() {
+	var (
+		store *PartialInMemoryConfig
+	)
+
+	BeforeEach(func() {
+		store = NewPartialInMemoryConfig(nil)
+	})
+
+	It("bumps the resource version on every write", func() {
+		created, err := store.V1().Roles().Create(&v1.Role{Name: "web-proxy"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(created.Metadata.ResourceVersion).NotTo(BeEmpty())
+
+		created.Listeners = []*v1.Listener{{Name: "web-proxy-inbound"}}
+		updated, err := store.V1().Roles().Update(created)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(updated.Metadata.ResourceVersion).NotTo(Equal(created.Metadata.ResourceVersion))
+	})
+
+	It("rejects updates without or with a stale resource version", func() {
+		created, err := store.V1().Roles().Create(&v1.Role{Name: "web-proxy"})
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = store.V1().Roles().Update(&v1.Role{Name: "web-proxy"})
+		Expect(err).To(HaveOccurred())
+
+		_, err = store.V1().Roles().Update(created)
+		Expect(err).NotTo(HaveOccurred())
+		_, err = store.V1().Roles().Update(created)
+		Expect(err).To(HaveOccurred())
+		Expect(IsConflict(err)).To(BeTrue())
+		Expect(IsConflict(errors.Wrap(err, "updating role"))).To(BeTrue())
+	})
+
+	It("doesn't share objects with callers", func() {
+		role := &v1.Role{Name: "web-proxy"}
+		_, err := store.V1().Roles().Create(role)
+		Expect(err).NotTo(HaveOccurred())
+		role.Listeners = []*v1.Listener{{Name: "leaked"}}
+
+		got, err := store.V1().Roles().Get("web-proxy")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(got.Listeners).To(BeEmpty())
+		got.Listeners = []*v1.Listener{{Name: "leaked"}}
+
+		list, err := store.V1().Roles().List()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(list).To(HaveLen(1))
+		Expect(list[0].Listeners).To(BeEmpty())
+	})
+
+	It("sends every watcher the current state, then each change in order", func() {
+		_, err := store.V1().Roles().Create(&v1.Role{Name: "a"})
+		Expect(err).NotTo(HaveOccurred())
+
+		stop := make(chan struct{})
+		defer close(stop)
+		var handlers []*recordingRoleHandler
+		for i := 0; i < 2; i++ {
+			h := &recordingRoleHandler{events: make(chan string, 10)}
+			w, err := store.V1().Roles().Watch(h)
+			Expect(err).NotTo(HaveOccurred())
+			go w.Run(stop, make(chan error, 1))
+			handlers = append(handlers, h)
+		}
+
+		b, err := store.V1().Roles().Create(&v1.Role{Name: "b"})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = store.V1().Roles().Update(b)
+		Expect(err).NotTo(HaveOccurred())
+		err = store.V1().Roles().Delete("a")
+		Expect(err).NotTo(HaveOccurred())
+
+		for _, h := range handlers {
+			Eventually(h.events).Should(Receive(Equal("update - 1")))
+			Eventually(h.events).Should(Receive(Equal("add b 2")))
+			Eventually(h.events).Should(Receive(Equal("update b 2")))
+			Eventually(h.events).Should(Receive(Equal("delete a 1")))
+		}
+	})
+})
@@ -0,0 +1,206 @@
+package storage
+
+import (
+	"sync"
+
+	"github.com/solo-io/gloo/pkg/api/types/v1"
+	"github.com/solo-io/gloo/pkg/log"
+	"github.com/solo-io/gloo/pkg/storage"
+)
+
+// ScopedConfig is the store upstream discovery writes to. It drops the
+// upstreams that are out of scope, and deletes the ones it let through once
+// they leave the scope. Everything else goes to the wrapped store.
+type ScopedConfig struct {
+	gloo      storage.Interface
+	upstreams *scopedUpstreams
+}
+
+var _ storage.Interface = &ScopedConfig{}
+
+// the scope is empty until it is set
+func NewScopedConfig(gloo storage.Interface) *ScopedConfig {
+	return &ScopedConfig{
+		gloo: gloo,
+		upstreams: &scopedUpstreams{
+			gloo:    gloo.V1().Upstreams(),
+			scope:   make(map[string]bool),
+			created: make(map[string]bool),
+			widened: make(chan struct{}, 1),
+		},
+	}
+}
+
+func (s *ScopedConfig) V1() storage.V1 {
+	return s
+}
+
+func (s *ScopedConfig) Register() error {
+	return s.gloo.V1().Register()
+}
+
+func (s *ScopedConfig) Upstreams() storage.Upstreams {
+	return s.upstreams
+}
+
+func (s *ScopedConfig) VirtualServices() storage.VirtualServices {
+	return s.gloo.V1().VirtualServices()
+}
+
+func (s *ScopedConfig) Attributes() storage.Attributes {
+	return s.gloo.V1().Attributes()
+}
+
+func (s *ScopedConfig) Roles() storage.Roles {
+	return s.gloo.V1().Roles()
+}
+
+// SetScope sets the names of the upstreams that discovery may write
+func (s *ScopedConfig) SetScope(names []string) {
+	s.upstreams.setScope(names)
+}
+
+// Widened is signalled when upstreams were added to the scope. Discovery only
+// writes an upstream when the service changes, so it has to start over to
+// write the new ones.
+func (s *ScopedConfig) Widened() <-chan struct{} {
+	return s.upstreams.widened
+}
+
+type scopedUpstreams struct {
+	gloo storage.Upstreams
+
+	lock  sync.Mutex
+	scope map[string]bool
+	// the upstreams that were created or updated through this store
+	created map[string]bool
+	widened chan struct{}
+}
+
+func (s *scopedUpstreams) setScope(names []string) {
+	scope := make(map[string]bool)
+	for _, name := range names {
+		scope[name] = true
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var widened bool
+	for name := range scope {
+		if !s.scope[name] {
+			widened = true
+		}
+	}
+	s.scope = scope
+	for name := range s.created {
+		if scope[name] {
+			continue
+		}
+		delete(s.created, name)
+		if err := s.gloo.Delete(name); err != nil {
+			log.Warnf("failed to delete upstream %v after it left the discovery scope: %v", name, err)
+		}
+	}
+	if widened {
+		select {
+		case s.widened <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (s *scopedUpstreams) inScope(name string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.scope[name]
+}
+
+func (s *scopedUpstreams) Create(obj *v1.Upstream) (*v1.Upstream, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.scope[obj.Name] {
+		return obj, nil
+	}
+	out, err := s.gloo.Create(obj)
+	if err != nil {
+		return nil, err
+	}
+	s.created[obj.Name] = true
+	return out, nil
+}
+
+func (s *scopedUpstreams) Update(obj *v1.Upstream) (*v1.Upstream, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.scope[obj.Name] {
+		return obj, nil
+	}
+	out, err := s.gloo.Update(obj)
+	if err != nil {
+		return nil, err
+	}
+	// discovery updates the upstreams it created before it was restarted
+	s.created[obj.Name] = true
+	return out, nil
+}
+
+func (s *scopedUpstreams) Delete(name string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.created, name)
+	return s.gloo.Delete(name)
+}
+
+func (s *scopedUpstreams) Get(name string) (*v1.Upstream, error) {
+	return s.gloo.Get(name)
+}
+
+func (s *scopedUpstreams) List() ([]*v1.Upstream, error) {
+	upstreams, err := s.gloo.List()
+	if err != nil {
+		return nil, err
+	}
+	return s.filter(upstreams), nil
+}
+
+func (s *scopedUpstreams) filter(upstreams []*v1.Upstream) []*v1.Upstream {
+	var out []*v1.Upstream
+	for _, us := range upstreams {
+		if s.inScope(us.Name) {
+			out = append(out, us)
+		}
+	}
+	return out
+}
+
+func (s *scopedUpstreams) Watch(handlers ...storage.UpstreamEventHandler) (*storage.Watcher, error) {
+	var scoped []storage.UpstreamEventHandler
+	for _, h := range handlers {
+		scoped = append(scoped, &scopedUpstreamEventHandler{upstreams: s, handler: h})
+	}
+	return s.gloo.Watch(scoped...)
+}
+
+// scopedUpstreamEventHandler hides the upstreams that are out of scope from a watch
+type scopedUpstreamEventHandler struct {
+	upstreams *scopedUpstreams
+	handler   storage.UpstreamEventHandler
+}
+
+func (h *scopedUpstreamEventHandler) OnAdd(updatedList []*v1.Upstream, obj *v1.Upstream) {
+	if obj == nil || h.upstreams.inScope(obj.Name) {
+		h.handler.OnAdd(h.upstreams.filter(updatedList), obj)
+	}
+}
+
+func (h *scopedUpstreamEventHandler) OnUpdate(updatedList []*v1.Upstream, newObj *v1.Upstream) {
+	if newObj == nil || h.upstreams.inScope(newObj.Name) {
+		h.handler.OnUpdate(h.upstreams.filter(updatedList), newObj)
+	}
+}
+
+func (h *scopedUpstreamEventHandler) OnDelete(updatedList []*v1.Upstream, obj *v1.Upstream) {
+	if obj == nil || h.upstreams.inScope(obj.Name) {
+		h.handler.OnDelete(h.upstreams.filter(updatedList), obj)
+	}
+}
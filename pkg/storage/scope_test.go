@@ -0,0 +1,75 @@
+package storage_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/solo-io/gloo-connect/pkg/storage"
+	"github.com/solo-io/gloo/pkg/api/types/v1"
+)
+
+var _ = Describe("ScopedConfig", func() {
+	var (
+		store  *PartialInMemoryConfig
+		scoped *ScopedConfig
+	)
+
+	BeforeEach(func() {
+		store = NewPartialInMemoryConfig(nil)
+		scoped = NewScopedConfig(store)
+	})
+
+	names := func() []string {
+		upstreams, err := store.V1().Upstreams().List()
+		Expect(err).NotTo(HaveOccurred())
+		var names []string
+		for _, us := range upstreams {
+			names = append(names, us.Name)
+		}
+		return names
+	}
+
+	It("only writes upstreams in scope", func() {
+		scoped.SetScope([]string{"web"})
+		_, err := scoped.V1().Upstreams().Create(&v1.Upstream{Name: "web"})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = scoped.V1().Upstreams().Create(&v1.Upstream{Name: "db"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(names()).To(Equal([]string{"web"}))
+	})
+
+	It("deletes the upstreams that leave the scope", func() {
+		scoped.SetScope([]string{"web", "db"})
+		for _, name := range []string{"web", "db"} {
+			_, err := scoped.V1().Upstreams().Create(&v1.Upstream{Name: name})
+			Expect(err).NotTo(HaveOccurred())
+		}
+		scoped.SetScope([]string{"web"})
+		Expect(names()).To(Equal([]string{"web"}))
+	})
+
+	It("deletes the upstreams it only updated once they leave the scope", func() {
+		us, err := store.V1().Upstreams().Create(&v1.Upstream{Name: "db"})
+		Expect(err).NotTo(HaveOccurred())
+		scoped.SetScope([]string{"db"})
+		_, err = scoped.V1().Upstreams().Update(us)
+		Expect(err).NotTo(HaveOccurred())
+		scoped.SetScope(nil)
+		Expect(names()).To(BeEmpty())
+	})
+
+	It("leaves upstreams it didn't write alone", func() {
+		_, err := store.V1().Upstreams().Create(&v1.Upstream{Name: "resolved"})
+		Expect(err).NotTo(HaveOccurred())
+		scoped.SetScope([]string{"resolved"})
+		scoped.SetScope(nil)
+		Expect(names()).To(Equal([]string{"resolved"}))
+	})
+
+	It("signals when the scope widened", func() {
+		scoped.SetScope([]string{"web"})
+		Eventually(scoped.Widened()).Should(Receive())
+		scoped.SetScope(nil)
+		Consistently(scoped.Widened()).ShouldNot(Receive())
+	})
+})
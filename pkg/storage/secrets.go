@@ -1,37 +1,63 @@
 package storage
 
 import (
-	"github.com/solo-io/gloo/pkg/storage/dependencies"
-	"github.com/solo-io/gloo/pkg/storage"
-	"github.com/pkg/errors"
 	"sort"
-	"github.com/solo-io/gloo/pkg/log"
 	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/solo-io/gloo/pkg/storage"
+	"github.com/solo-io/gloo/pkg/storage/dependencies"
 )
 
 /**************************************
 	Base
  *************************************/
 
+// InMemorySecrets keeps copies of its secrets, so callers can't change them
+// behind the watchers' backs
 type InMemorySecrets struct {
 	lock    sync.RWMutex
 	objects map[string]*dependencies.Secret
-	updates chan struct{}
+	watches *watchQueues
 }
 
 func NewInMemorySecrets() *InMemorySecrets {
 	return &InMemorySecrets{
 		objects: make(map[string]*dependencies.Secret),
-		updates: make(chan struct{}, 10),
+		watches: newWatchQueues(),
 	}
 }
 
 var _ dependencies.SecretStorage = &InMemorySecrets{}
 
-func (s *InMemorySecrets) updated() {
-	go func() {
-		s.updates <- struct{}{}
-	}()
+func copySecret(obj *dependencies.Secret) *dependencies.Secret {
+	data := make(map[string]string, len(obj.Data))
+	for k, v := range obj.Data {
+		data[k] = v
+	}
+	return &dependencies.Secret{
+		Ref:  obj.Ref,
+		Data: data,
+	}
+}
+
+// snapshot returns the stored secrets sorted by ref; the caller holds the lock
+func (s *InMemorySecrets) snapshot() []interface{} {
+	var refs []string
+	for ref := range s.objects {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+	var objs []interface{}
+	for _, ref := range refs {
+		objs = append(objs, s.objects[ref])
+	}
+	return objs
+}
+
+// notify queues an event for every watcher; the caller holds the write lock
+func (s *InMemorySecrets) notify(typ eventType, obj *dependencies.Secret) {
+	s.watches.notify(event{typ: typ, obj: obj, list: s.snapshot()})
 }
 
 func (s *InMemorySecrets) Create(obj *dependencies.Secret) (*dependencies.Secret, error) {
@@ -40,9 +66,10 @@ func (s *InMemorySecrets) Create(obj *dependencies.Secret) (*dependencies.Secret
 	if _, exists := s.objects[obj.Ref]; exists {
 		return nil, storage.NewAlreadyExistsErr(errors.Errorf("%s already exists", obj.Ref))
 	}
-	s.objects[obj.Ref] = obj
-	s.updated()
-	return obj, nil
+	stored := copySecret(obj)
+	s.objects[obj.Ref] = stored
+	s.notify(eventAdd, stored)
+	return copySecret(stored), nil
 }
 
 func (s *InMemorySecrets) Update(obj *dependencies.Secret) (*dependencies.Secret, error) {
@@ -51,19 +78,21 @@ func (s *InMemorySecrets) Update(obj *dependencies.Secret) (*dependencies.Secret
 	if _, exists := s.objects[obj.Ref]; !exists {
 		return nil, errors.Errorf("%s does not exist", obj.Ref)
 	}
-	s.objects[obj.Ref] = obj
-	s.updated()
-	return obj, nil
+	stored := copySecret(obj)
+	s.objects[obj.Ref] = stored
+	s.notify(eventUpdate, stored)
+	return copySecret(stored), nil
 }
 
 func (s *InMemorySecrets) Delete(name string) error {
 	s.lock.Lock()
 	defer s.lock.Unlock()
-	if _, exists := s.objects[name]; !exists {
+	existing, exists := s.objects[name]
+	if !exists {
 		return errors.Errorf("%s does not exist", name)
 	}
 	delete(s.objects, name)
-	s.updated()
+	s.notify(eventDelete, existing)
 	return nil
 }
 
@@ -74,41 +103,44 @@ func (s *InMemorySecrets) Get(name string) (*dependencies.Secret, error) {
 	if !exists {
 		return nil, errors.Errorf("%s does not exist", name)
 	}
-	return obj, nil
+	return copySecret(obj), nil
 }
 
 func (s *InMemorySecrets) List() ([]*dependencies.Secret, error) {
 	s.lock.RLock()
 	defer s.lock.RUnlock()
 	var objs []*dependencies.Secret
-	for _, obj := range s.objects {
-		objs = append(objs, obj)
+	for _, obj := range s.snapshot() {
+		objs = append(objs, copySecret(obj.(*dependencies.Secret)))
 	}
-	sort.SliceStable(objs, func(i, j int) bool {
-		return objs[i].Ref < objs[j].Ref
-	})
 	return objs, nil
 }
 
-func (s *InMemorySecrets) Watch(handlers ... dependencies.SecretEventHandler) (*storage.Watcher, error) {
+// Watch sends the current secrets to the handlers first, and then every change in order
+func (s *InMemorySecrets) Watch(handlers ...dependencies.SecretEventHandler) (*storage.Watcher, error) {
+	s.lock.RLock()
+	q := s.watches.add(s.snapshot())
+	s.lock.RUnlock()
 	return storage.NewWatcher(func(stop <-chan struct{}, errs chan error) {
-		for {
-			select {
-			case <-s.updates:
-				secrets, err := s.List()
-				if err != nil {
-					log.Warnf("failed to list config objects: %v", err)
-					continue
-				}
-				for _, h := range handlers {
-					h.OnUpdate(secrets, nil)
+		s.watches.watch(q, stop, func(e event) {
+			var list []*dependencies.Secret
+			for _, obj := range e.list {
+				list = append(list, copySecret(obj.(*dependencies.Secret)))
+			}
+			var obj *dependencies.Secret
+			if e.obj != nil {
+				obj = copySecret(e.obj.(*dependencies.Secret))
+			}
+			for _, h := range handlers {
+				switch e.typ {
+				case eventAdd:
+					h.OnAdd(list, obj)
+				case eventDelete:
+					h.OnDelete(list, obj)
+				default:
+					h.OnUpdate(list, obj)
 				}
-			case err := <-errs:
-				log.Warnf("failed to start watcher: %v", err)
-				return
-			case <-stop:
-				return
 			}
-		}
+		})
 	}), nil
 }
@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"sync"
+)
+
+type eventType int
+
+const (
+	// the full current state; sent first to every watcher, and in place of
+	// the queued events when a watcher falls behind
+	eventResync eventType = iota
+	eventAdd
+	eventUpdate
+	eventDelete
+)
+
+// event is a change to a store. list is the state of the store right after
+// the change; the objects in it are never modified by the store.
+type event struct {
+	typ  eventType
+	obj  interface{}
+	list []interface{}
+}
+
+// how many events a watcher can fall behind before they are coalesced
+const watchQueueSize = 100
+
+// watchQueue holds the events for one watcher, in order
+type watchQueue struct {
+	lock   sync.Mutex
+	events []event
+	ready  chan struct{}
+}
+
+func newWatchQueue() *watchQueue {
+	return &watchQueue{
+		ready: make(chan struct{}, 1),
+	}
+}
+
+func (q *watchQueue) push(e event) {
+	q.lock.Lock()
+	if len(q.events) >= watchQueueSize {
+		// the watcher fell behind; it only needs the latest state
+		q.events = []event{{typ: eventResync, list: e.list}}
+	} else {
+		q.events = append(q.events, e)
+	}
+	q.lock.Unlock()
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (q *watchQueue) pop() []event {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	events := q.events
+	q.events = nil
+	return events
+}
+
+// watchQueues fans the events of a store out to all of its watchers
+type watchQueues struct {
+	lock   sync.Mutex
+	queues map[*watchQueue]struct{}
+}
+
+func newWatchQueues() *watchQueues {
+	return &watchQueues{
+		queues: make(map[*watchQueue]struct{}),
+	}
+}
+
+// add registers a watcher, which gets the current state first. the store
+// must not change between reading current and adding the watcher.
+func (w *watchQueues) add(current []interface{}) *watchQueue {
+	q := newWatchQueue()
+	q.push(event{typ: eventResync, list: current})
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.queues[q] = struct{}{}
+	return q
+}
+
+func (w *watchQueues) remove(q *watchQueue) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	delete(w.queues, q)
+}
+
+// notify must be called with the store's write lock held, so every watcher
+// sees the events in the order they happened
+func (w *watchQueues) notify(e event) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	for q := range w.queues {
+		q.push(e)
+	}
+}
+
+// watch delivers the events of q to deliver until stop is closed
+func (w *watchQueues) watch(q *watchQueue, stop <-chan struct{}, deliver func(event)) {
+	defer w.remove(q)
+	for {
+		select {
+		case <-q.ready:
+			for _, e := range q.pop() {
+				deliver(e)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
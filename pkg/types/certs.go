@@ -0,0 +1,21 @@
+package types
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"time"
+)
+
+// NotAfter returns the expiry of the first certificate in the pem data
+func (c Certificate) NotAfter() (time.Time, error) {
+	block, _ := pem.Decode([]byte(c))
+	if block == nil {
+		return time.Time{}, errors.New("no pem data found in certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return cert.NotAfter, nil
+}